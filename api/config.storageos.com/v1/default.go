@@ -15,6 +15,12 @@ limitations under the License.
 */
 package v1
 
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 func (c *MetricsExporterConfig) Default() *MetricsExporterConfig {
 	if c.LogLevel == "" {
 		c.LogLevel = "info"
@@ -22,5 +28,30 @@ func (c *MetricsExporterConfig) Default() *MetricsExporterConfig {
 	if c.Timeout == 0 {
 		c.Timeout = 10
 	}
+	if c.StuckMountTimeout == 0 {
+		c.StuckMountTimeout = 5
+	}
+	if len(c.DiskStatsSources) == 0 {
+		c.DiskStatsSources = []MetricsExporterDiskStatsSource{
+			MetricsExporterDiskStatsSourceProcFS,
+			MetricsExporterDiskStatsSourceSysFS,
+		}
+	}
+	if len(c.LatencyBuckets) == 0 {
+		c.LatencyBuckets = []metav1.Duration{
+			{Duration: 500 * time.Microsecond},
+			{Duration: time.Millisecond},
+			{Duration: 2500 * time.Microsecond},
+			{Duration: 5 * time.Millisecond},
+			{Duration: 10 * time.Millisecond},
+			{Duration: 25 * time.Millisecond},
+			{Duration: 50 * time.Millisecond},
+			{Duration: 100 * time.Millisecond},
+			{Duration: 250 * time.Millisecond},
+			{Duration: 500 * time.Millisecond},
+			{Duration: time.Second},
+			{Duration: 2500 * time.Millisecond},
+		}
+	}
 	return c
 }
@@ -44,18 +44,76 @@ type MetricsExporterConfigSpec struct {
 	// +kubebuilder:validation:Minimum=1
 	Timeout int `json:"timeout,omitempty"`
 
+	// StuckMountTimeout in seconds after which a mount point whose statfs() did not return is marked as
+	// stuck. Stuck mounts are probed in the background until they answer again.
+	// +kubebuilder:default:5
+	// +kubebuilder:validation:Minimum=1
+	StuckMountTimeout int `json:"stuckMountTimeout,omitempty"`
+
+	// MountPollInterval in milliseconds at which the filesystem collector checks the mount table in the
+	// background, on top of waiting for its changes, to catch read-only transitions between scrapes. The
+	// background poll is disabled when 0, shorter intervals than 100ms are raised to 100ms.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:XValidation:rule="self == 0 || self >= 100",message="mountPollInterval must be 0 or at least 100ms"
+	MountPollInterval int `json:"mountPollInterval,omitempty"`
+
 	// DisabledCollectors is a list of collectors that shall be disabled. By default, all are enabled.
 	DisabledCollectors []MetricsExporterCollector `json:"disabledCollectors,omitempty"`
+
+	// DiskStatsSources is the ordered list of sources the diskstats collector reads the I/O statistics of
+	// a device from, the next one is tried when a source fails. By default, procfs then sysfs.
+	DiskStatsSources []MetricsExporterDiskStatsSource `json:"diskStatsSources,omitempty"`
+
+	// MonotonicDiskCounters keeps the diskstats counters of a PVC increasing when its volume is detached then
+	// attached again to the node, by adding the last values of its previous devices. The offsets are only
+	// kept for the lifetime of the exporter.
+	MonotonicDiskCounters bool `json:"monotonicDiskCounters,omitempty"`
+
+	// SamplingInterval in milliseconds at which the sampler collector reads the stats of the Ondat devices in
+	// the background, to report what happens between scrapes. The sampler is disabled when 0, shorter
+	// intervals than 100ms are raised to 100ms.
+	// +kubebuilder:validation:Minimum=0
+	SamplingInterval int `json:"samplingInterval,omitempty"`
+
+	// LatencyBuckets are the upper bounds of the buckets of the read and write latency histograms of the
+	// sampler collector, e.g. "5ms". By default, from 500us to 2.5s.
+	LatencyBuckets []metav1.Duration `json:"latencyBuckets,omitempty"`
+
+	// ProjectQuotas enables the quota collector, reporting the usage and limits of the XFS and ext4 project
+	// quotas of the Ondat volumes.
+	ProjectQuotas bool `json:"projectQuotas,omitempty"`
+
+	// ProjectIDsFile is the host path of a projid(5) file naming the projects of the quota collector, e.g.
+	// "/etc/projid". Projects are only reported by ID when empty.
+	ProjectIDsFile string `json:"projectIDsFile,omitempty"`
 }
 
 // MetricsExporterCollector is the name of a metrics collector in the metrics-exporter.
-// +kubebuilder:validation:Enum=diskstats;filesystem
+// +kubebuilder:validation:Enum=diskstats;filesystem;blockqueue;sampler;ext4;xfs;bdi;quota
 type MetricsExporterCollector string
 
 // All known metrics-exporter collectors are listed here.
 const (
 	MetricsExporterCollectorDiskStats  MetricsExporterCollector = "diskstats"
 	MetricsExporterCollectorFileSystem MetricsExporterCollector = "filesystem"
+	MetricsExporterCollectorBlockQueue MetricsExporterCollector = "blockqueue"
+	MetricsExporterCollectorSampler    MetricsExporterCollector = "sampler"
+	MetricsExporterCollectorExt4       MetricsExporterCollector = "ext4"
+	MetricsExporterCollectorXFS        MetricsExporterCollector = "xfs"
+	MetricsExporterCollectorBDI        MetricsExporterCollector = "bdi"
+	MetricsExporterCollectorQuota      MetricsExporterCollector = "quota"
+)
+
+// MetricsExporterDiskStatsSource is where the diskstats collector reads the I/O statistics of a device from.
+// +kubebuilder:validation:Enum=procfs;sysfs
+type MetricsExporterDiskStatsSource string
+
+// All known diskstats sources are listed here.
+const (
+	// MetricsExporterDiskStatsSourceProcFS is the /proc/diskstats file of the node.
+	MetricsExporterDiskStatsSourceProcFS MetricsExporterDiskStatsSource = "procfs"
+	// MetricsExporterDiskStatsSourceSysFS is the /sys/block/<dev>/stat file of each device.
+	MetricsExporterDiskStatsSourceSysFS MetricsExporterDiskStatsSource = "sysfs"
 )
 
 func init() {
@@ -6,6 +6,7 @@
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -43,6 +44,16 @@ func (in *MetricsExporterConfigSpec) DeepCopyInto(out *MetricsExporterConfigSpec
 		*out = make([]MetricsExporterCollector, len(*in))
 		copy(*out, *in)
 	}
+	if in.DiskStatsSources != nil {
+		in, out := &in.DiskStatsSources, &out.DiskStatsSources
+		*out = make([]MetricsExporterDiskStatsSource, len(*in))
+		copy(*out, *in)
+	}
+	if in.LatencyBuckets != nil {
+		in, out := &in.LatencyBuckets, &out.LatencyBuckets
+		*out = make([]metav1.Duration, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsExporterConfigSpec.
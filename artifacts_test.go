@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	PROMETHEUS_RULES_PATH = "alertmanager/ondat-prom-rules.yaml"
+	GRAFANA_DASHBOARD     = "grafana/ondat-volume.json"
+)
+
+// targetLabels are attached by Prometheus when scraping the exporter, they are
+// valid in any selector even though the collectors never set them.
+var targetLabels = map[string]struct{}{
+	"job": {}, "instance": {}, "namespace": {}, "pod": {}, "service": {}, "endpoint": {}, "container": {},
+}
+
+// promQLKeywords are identifiers that are never metric names.
+var promQLKeywords = map[string]struct{}{
+	"and": {}, "or": {}, "unless": {}, "bool": {}, "offset": {},
+	"by": {}, "without": {}, "on": {}, "ignoring": {}, "group_left": {}, "group_right": {},
+	"inf": {}, "nan": {},
+}
+
+// promQLGrouping are keywords followed by a list of label names.
+var promQLGrouping = map[string]struct{}{
+	"by": {}, "without": {}, "on": {}, "ignoring": {}, "group_left": {}, "group_right": {},
+}
+
+// selector is a metric selector found in a PromQL expression.
+type selector struct {
+	metric string
+	labels []string
+}
+
+var (
+	promQLIdentifier = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*`)
+	promQLMatcher    = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|` + "`[^`]*`" + `)\s*,?`)
+)
+
+// extractSelectors returns the metric selectors of the given PromQL expression.
+// It is not a full parser, it only understands enough of the language to tell
+// metric names from functions, keywords and label names.
+func extractSelectors(expr string) ([]selector, error) {
+	var selectors []selector
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d in %q", i, expr)
+			}
+			i += end + 2
+		case c == '#':
+			end := strings.IndexByte(expr[i:], '\n')
+			if end < 0 {
+				return selectors, nil
+			}
+			i += end
+		case c == '[':
+			// range or subquery duration
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated range at %d in %q", i, expr)
+			}
+			i += end + 1
+		case c >= '0' && c <= '9' || c == '.':
+			// numbers, and the units of durations that follow them
+			for i < len(expr) && (isAlphaNum(expr[i]) || expr[i] == '.') {
+				i++
+			}
+		case c == '$':
+			// grafana variables
+			i++
+			for i < len(expr) && (isAlphaNum(expr[i]) || expr[i] == '_') {
+				i++
+			}
+		case c == '{':
+			sel, n, err := parseMatchers(expr[i:])
+			if err != nil {
+				return nil, err
+			}
+			selectors = append(selectors, sel)
+			i += n
+		case promQLIdentifier.MatchString(expr[i:]):
+			ident := promQLIdentifier.FindString(expr[i:])
+			i += len(ident)
+			next := strings.TrimLeft(expr[i:], " \t\n")
+
+			if _, ok := promQLGrouping[strings.ToLower(ident)]; ok && strings.HasPrefix(next, "(") {
+				// skip the list of label names
+				end := strings.IndexByte(next, ')')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated label list after %s in %q", ident, expr)
+				}
+				i = len(expr) - len(next) + end + 1
+				continue
+			}
+			if _, ok := promQLKeywords[strings.ToLower(ident)]; ok {
+				continue
+			}
+			if strings.HasPrefix(next, "(") {
+				// function or aggregation
+				continue
+			}
+			if clause := strings.ToLower(promQLIdentifier.FindString(next)); clause == "by" || clause == "without" {
+				// aggregation with its grouping clause first
+				continue
+			}
+
+			sel := selector{metric: ident}
+			if strings.HasPrefix(next, "{") {
+				matchers, n, err := parseMatchers(next)
+				if err != nil {
+					return nil, err
+				}
+				sel.labels = matchers.labels
+				i = len(expr) - len(next) + n
+			}
+			selectors = append(selectors, sel)
+		default:
+			i++
+		}
+	}
+
+	return selectors, nil
+}
+
+// parseMatchers parses the label matchers in braces at the start of s and
+// returns the number of bytes consumed.
+func parseMatchers(s string) (selector, int, error) {
+	sel := selector{}
+	i := 1
+	for {
+		rest := s[i:]
+		trimmed := strings.TrimLeft(rest, " \t\n")
+		if strings.HasPrefix(trimmed, "}") {
+			return sel, i + len(rest) - len(trimmed) + 1, nil
+		}
+
+		m := promQLMatcher.FindStringSubmatch(rest)
+		if m == nil {
+			return sel, 0, fmt.Errorf("unexpected label matcher in %q", s)
+		}
+		if m[1] == "__name__" && m[2] == "=" {
+			sel.metric = strings.Trim(m[3], "\"'`")
+		} else {
+			sel.labels = append(sel.labels, m[1])
+		}
+		i += len(m[0])
+	}
+}
+
+func isAlphaNum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func TestExtractSelectors(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected []selector
+	}{
+		{
+			expr:     `ondat_filesystem_readonly{job="svc"} == 1`,
+			expected: []selector{{metric: "ondat_filesystem_readonly", labels: []string{"job"}}},
+		},
+		{
+			expr: `max without(pod, device) (ondat_a{pvc_namespace="$namespace", pvc=~"$volume"}) / on (pvc) ondat_b`,
+			expected: []selector{
+				{metric: "ondat_a", labels: []string{"pvc_namespace", "pvc"}},
+				{metric: "ondat_b"},
+			},
+		},
+		{
+			expr:     `predict_linear(ondat_a{fstype!=""}[6h], 24*60*60) < 0 and ondat_b offset 5m`,
+			expected: []selector{{metric: "ondat_a", labels: []string{"fstype"}}, {metric: "ondat_b"}},
+		},
+		{
+			expr:     `sum by (pvc) (irate({__name__="ondat_c", pvc="a,}b"}[$__rate_interval]))`,
+			expected: []selector{{metric: "ondat_c", labels: []string{"pvc"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := extractSelectors(tt.expr)
+		require.NoError(t, err, tt.expr)
+		require.Equal(t, tt.expected, got, tt.expr)
+	}
+}
+
+// grafanaPanel holds the queries of a dashboard panel, rows nest panels.
+type grafanaPanel struct {
+	Title   string         `json:"title"`
+	Panels  []grafanaPanel `json:"panels"`
+	Targets []struct {
+		Expr string `json:"expr"`
+	} `json:"targets"`
+}
+
+// dashboardQueries returns the PromQL queries of the given grafana dashboard,
+// keyed by where they were found. Variables defined by label_values() are
+// returned as their selector and the label they read.
+func dashboardQueries(t *testing.T, path string) (map[string]string, map[string]string) {
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	dashboard := struct {
+		Panels     []grafanaPanel `json:"panels"`
+		Templating struct {
+			List []struct {
+				Name  string          `json:"name"`
+				Type  string          `json:"type"`
+				Query json.RawMessage `json:"query"`
+			} `json:"list"`
+		} `json:"templating"`
+	}{}
+	require.NoError(t, json.Unmarshal(content, &dashboard))
+
+	queries := map[string]string{}
+	var walk func(panels []grafanaPanel)
+	walk = func(panels []grafanaPanel) {
+		for _, p := range panels {
+			for i, target := range p.Targets {
+				if target.Expr != "" {
+					queries[fmt.Sprintf("panel %q target %d", p.Title, i)] = target.Expr
+				}
+			}
+			walk(p.Panels)
+		}
+	}
+	walk(dashboard.Panels)
+
+	labelValues := regexp.MustCompile(`^\s*label_values\((.*),\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\)\s*$`)
+	variables := map[string]string{}
+	for _, v := range dashboard.Templating.List {
+		if v.Type != "query" {
+			continue
+		}
+		query := struct {
+			Query string `json:"query"`
+		}{}
+		if err := json.Unmarshal(v.Query, &query); err != nil {
+			require.NoError(t, json.Unmarshal(v.Query, &query.Query), "variable %s", v.Name)
+		}
+
+		m := labelValues.FindStringSubmatch(query.Query)
+		require.NotNil(t, m, "unexpected query for variable %s: %s", v.Name, query.Query)
+		queries[fmt.Sprintf("variable %q", v.Name)] = m[1]
+		variables[m[1]] = m[2]
+	}
+
+	return queries, variables
+}
+
+// rulesQueries returns the PromQL expressions of the given PrometheusRule,
+// keyed by alert or record name.
+func rulesQueries(t *testing.T, path string) map[string]string {
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	rules := struct {
+		Spec struct {
+			Groups []struct {
+				Rules []struct {
+					Alert  string `json:"alert"`
+					Record string `json:"record"`
+					Expr   string `json:"expr"`
+				} `json:"rules"`
+			} `json:"groups"`
+		} `json:"spec"`
+	}{}
+	require.NoError(t, yaml.Unmarshal(content, &rules))
+
+	queries := map[string]string{}
+	for _, group := range rules.Spec.Groups {
+		for i, rule := range group.Rules {
+			queries[fmt.Sprintf("rule %d %s%s", i, rule.Alert, rule.Record)] = rule.Expr
+		}
+	}
+	return queries
+}
+
+// TestArtifactsMatchMetrics checks that every metric and label matcher used by
+// the PrometheusRule alerts and the grafana dashboard exist in the metrics
+// defined by the collectors.
+func TestArtifactsMatchMetrics(t *testing.T) {
+	refs, err := metricsReference(newMetricsCollectors(zap.NewNop().Sugar(), referenceConfigSpec, allKernelFeatures))
+	require.NoError(t, err)
+	labels := map[string]map[string]struct{}{}
+	for _, ref := range refs {
+		labels[ref.Name] = map[string]struct{}{}
+		for _, l := range ref.Labels {
+			labels[ref.Name][l] = struct{}{}
+		}
+	}
+
+	dashboard, variables := dashboardQueries(t, GRAFANA_DASHBOARD)
+	artifacts := map[string]map[string]string{
+		PROMETHEUS_RULES_PATH: rulesQueries(t, PROMETHEUS_RULES_PATH),
+		GRAFANA_DASHBOARD:     dashboard,
+	}
+
+	for path, queries := range artifacts {
+		require.NotEmpty(t, queries, "no queries found in %s", path)
+		for where, query := range queries {
+			selectors, err := extractSelectors(query)
+			require.NoError(t, err, "%s: %s", path, where)
+			require.NotEmpty(t, selectors, "%s: %s: no metric in %q", path, where, query)
+
+			for _, sel := range selectors {
+				known, ok := labels[sel.metric]
+				if !check(t, ok, "%s: %s: unknown metric %s", path, where, sel.metric) {
+					continue
+				}
+
+				used := sel.labels
+				if label, ok := variables[query]; ok {
+					used = append(used, label)
+				}
+				for _, l := range used {
+					_, isTarget := targetLabels[l]
+					_, exists := known[l]
+					check(t, isTarget || exists, "%s: %s: metric %s has no label %s", path, where, sel.metric, l)
+				}
+			}
+		}
+	}
+}
+
+func check(t *testing.T, ok bool, format string, args ...interface{}) bool {
+	t.Helper()
+	if !ok {
+		t.Errorf(format, args...)
+	}
+	return ok
+}
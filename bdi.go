@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// bdiWritebackLists are the writeback lists of inodes reported in bdi stats.
+var bdiWritebackLists = []string{"b_dirty", "b_io", "b_more_io", "b_dirty_time"}
+
+// bdiStats are the writeback statistics of a backing device, in bytes.
+type bdiStats struct {
+	writeback      uint64
+	reclaimable    uint64
+	dirtyThreshold uint64
+	dirtied        uint64
+	written        uint64
+	// writeBandwidth is the estimated write bandwidth in bytes per second
+	writeBandwidth uint64
+	// inodes are the number of inodes on each writeback list
+	inodes map[string]uint64
+}
+
+// parseBDIStats parses the content of a bdi stats file:
+//
+//	BdiWriteback:            0 kB
+//	BdiReclaimable:        128 kB
+//	...
+//	WriteBandwidth:     102400 kBps
+//	b_dirty:                 2
+//	...
+//
+// The global thresholds and the state are ignored.
+func parseBDIStats(r io.Reader) (bdiStats, error) {
+	stats := bdiStats{inodes: map[string]uint64{}}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			return stats, fmt.Errorf("malformed bdi stats line: %q", scanner.Text())
+		}
+		key := strings.TrimSuffix(fields[0], ":")
+
+		var field *uint64
+		switch key {
+		case "BdiWriteback":
+			field = &stats.writeback
+		case "BdiReclaimable":
+			field = &stats.reclaimable
+		case "BdiDirtyThresh":
+			field = &stats.dirtyThreshold
+		case "BdiDirtied":
+			field = &stats.dirtied
+		case "BdiWritten":
+			field = &stats.written
+		case "WriteBandwidth":
+			field = &stats.writeBandwidth
+		case "b_dirty", "b_io", "b_more_io", "b_dirty_time":
+			val, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return stats, fmt.Errorf("invalid value in bdi stats line %q: %w", scanner.Text(), err)
+			}
+			stats.inodes[key] = val
+			continue
+		default:
+			continue
+		}
+
+		val, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return stats, fmt.Errorf("invalid value in bdi stats line %q: %w", scanner.Text(), err)
+		}
+		if len(fields) > 2 && strings.HasPrefix(fields[2], "kB") {
+			val *= KIBIBYTE
+		}
+		*field = val
+	}
+
+	return stats, scanner.Err()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+const BDI_COLLECTOR_NAME = string(configondatv1.MetricsExporterCollectorBDI)
+
+// bdiStatsPaths are the locations of the stats of a backing device, by order
+// of preference. Mainline kernels only expose them in debugfs.
+var bdiStatsPaths = []string{
+	"/sys/class/bdi/%d:%d/stats",
+	"/sys/kernel/debug/bdi/%d:%d/stats",
+}
+
+// BDICollector gathers the page cache writeback statistics of the backing
+// devices of the Ondat volumes, and of the devices stacked on top of them.
+type BDICollector struct {
+	writeback      Metric
+	reclaimable    Metric
+	dirtyThreshold Metric
+	dirtied        Metric
+	written        Metric
+	writeBandwidth Metric
+	inodes         Metric
+}
+
+func NewBDICollector() BDICollector {
+	return BDICollector{
+		writeback: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writeback_bytes"),
+			"Bytes of page cache being written back to the device.",
+			diskstatsLabels,
+			prometheus.GaugeValue,
+		),
+		reclaimable: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writeback_reclaimable_bytes"),
+			"Bytes of dirty page cache waiting to be written back to the device.",
+			diskstatsLabels,
+			prometheus.GaugeValue,
+		),
+		dirtyThreshold: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writeback_dirty_threshold_bytes"),
+			"Share of the dirty page cache threshold of the device, writers are throttled above it.",
+			diskstatsLabels,
+			prometheus.GaugeValue,
+		),
+		dirtied: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writeback_dirtied_bytes_total"),
+			"Bytes of page cache dirtied for the device.",
+			diskstatsLabels,
+			prometheus.CounterValue,
+		),
+		written: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writeback_written_bytes_total"),
+			"Bytes of page cache written back to the device.",
+			diskstatsLabels,
+			prometheus.CounterValue,
+		),
+		writeBandwidth: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writeback_bandwidth_bytes_per_second"),
+			"The kernel's estimate of the writeback bandwidth of the device.",
+			diskstatsLabels,
+			prometheus.GaugeValue,
+		),
+		inodes: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writeback_inodes"),
+			"Number of inodes on each writeback list of the device.",
+			append(diskstatsLabels, "list"),
+			prometheus.GaugeValue,
+		),
+	}
+}
+
+func (c BDICollector) Name() string {
+	return BDI_COLLECTOR_NAME
+}
+
+func (c BDICollector) collectsDevices() {}
+
+func (c BDICollector) Metrics() []Metric {
+	return []Metric{
+		c.writeback,
+		c.reclaimable,
+		c.dirtyThreshold,
+		c.dirtied,
+		c.written,
+		c.writeBandwidth,
+		c.inodes,
+	}
+}
+
+func (c BDICollector) Collect(log *zap.SugaredLogger, ch chan<- prometheus.Metric, ondatVolumes []*Volume) error {
+	log.Debug("starting bdi metrics collector")
+	log = log.With("collector", BDI_COLLECTOR_NAME)
+
+	if len(ondatVolumes) == 0 {
+		log.Debug("no Ondat volumes, metrics collector finished early")
+		return nil
+	}
+
+	// the Ondat devices, then the devices stacked on top of them as their
+	// filesystems dirty the page cache of the top most device
+	targets := make([]diskstatsTarget, 0, len(ondatVolumes))
+	for _, localVol := range ondatVolumes {
+		if localVol.Major == 0 && localVol.Minor == 0 {
+			// no block device for this volume on the node
+			continue
+		}
+		targets = append(targets, diskstatsTarget{volume: localVol, major: localVol.Major, minor: localVol.Minor})
+	}
+	for _, dev := range discoverStackedDevices(log, ondatVolumes) {
+		targets = append(targets, diskstatsTarget{volume: dev.volume, major: dev.major, minor: dev.minor, layer: dev.layer, layerDevice: dev.name})
+	}
+
+	for _, target := range targets {
+		localVol := target.volume
+		logScope := log.With("pvc", localVol.Labels.PVC, "pvc_namespace", localVol.Labels.PVCNamespace, "layer", target.layer, "layer_device", target.layerDevice)
+
+		stats, err := readBDIStats(target.major, target.minor)
+		if err != nil {
+			// debugfs is seldom mounted in containers
+			logScope.Debugw("error reading bdi stats", "major", target.major, "minor", target.minor, "error", err)
+			continue
+		}
+
+		emit := func(m Metric, val float64, extraLabels ...string) {
+			metric, err := prometheus.NewConstMetric(m.desc, m.valueType, val, append([]string{localVol.Labels.PVC, localVol.Labels.PVCNamespace, target.layer, target.layerDevice}, extraLabels...)...)
+			if err != nil {
+				logScope.Errorw("encountered error while building metric", "metric", m.desc.String(), "error", err)
+				return
+			}
+			ch <- metric
+		}
+		emit(c.writeback, float64(stats.writeback))
+		emit(c.reclaimable, float64(stats.reclaimable))
+		emit(c.dirtyThreshold, float64(stats.dirtyThreshold))
+		emit(c.dirtied, float64(stats.dirtied))
+		emit(c.written, float64(stats.written))
+		emit(c.writeBandwidth, float64(stats.writeBandwidth))
+		for _, list := range bdiWritebackLists {
+			if val, ok := stats.inodes[list]; ok {
+				emit(c.inodes, float64(val), list)
+			}
+		}
+	}
+
+	log.Debug("finished metrics collector")
+	return nil
+}
+
+// readBDIStats reads the stats of the backing device of the given device from
+// the first of bdiStatsPaths found.
+func readBDIStats(major, minor int) (bdiStats, error) {
+	var data []byte
+	var err error
+	for _, path := range bdiStatsPaths {
+		data, err = readHostFile(fmt.Sprintf(path, major, minor))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return bdiStats{}, err
+	}
+	return parseBDIStats(bytes.NewReader(data))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBDIStats(t *testing.T) {
+	content := `BdiWriteback:          256 kB
+BdiReclaimable:       4352 kB
+BdiDirtyThresh:      81920 kB
+DirtyThresh:        391692 kB
+BackgroundThresh:   195600 kB
+BdiDirtied:        5251072 kB
+BdiWritten:        5246720 kB
+WriteBandwidth:     148224 kBps
+b_dirty:                 3
+b_io:                    1
+b_more_io:               0
+bdi_list:                1
+state:                   a
+`
+	stats, err := parseBDIStats(strings.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, bdiStats{
+		writeback:      256 * KIBIBYTE,
+		reclaimable:    4352 * KIBIBYTE,
+		dirtyThreshold: 81920 * KIBIBYTE,
+		dirtied:        5251072 * KIBIBYTE,
+		written:        5246720 * KIBIBYTE,
+		writeBandwidth: 148224 * KIBIBYTE,
+		inodes:         map[string]uint64{"b_dirty": 3, "b_io": 1, "b_more_io": 0},
+	}, stats)
+
+	for _, content := range []string{
+		"BdiWriteback 0 kB\n",
+		"BdiWriteback:\n",
+		"BdiWritten: -1 kB\n",
+		"b_io: x\n",
+	} {
+		_, err := parseBDIStats(strings.NewReader(content))
+		require.Error(t, err, content)
+	}
+}
+
+func TestReadBDIStats(t *testing.T) {
+	root := t.TempDir()
+	previousRoot := hostRoot
+	hostRoot = root
+	t.Cleanup(func() { hostRoot = previousRoot })
+
+	write := func(path, content string) {
+		path = filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	_, err := readBDIStats(8, 32)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// debugfs is the fallback
+	write("sys/kernel/debug/bdi/8:32/stats", "BdiWritten: 2 kB\n")
+	stats, err := readBDIStats(8, 32)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2*KIBIBYTE), stats.written)
+
+	write("sys/class/bdi/8:32/stats", "BdiWritten: 1 kB\n")
+	stats, err = readBDIStats(8, 32)
+	require.NoError(t, err)
+	require.Equal(t, uint64(KIBIBYTE), stats.written)
+}
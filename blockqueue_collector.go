@@ -0,0 +1,197 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+const (
+	BLOCK_QUEUE_COLLECTOR_NAME = string(configondatv1.MetricsExporterCollectorBlockQueue)
+
+	// SYSFS_SECTOR_SIZE is the unit of /sys/block/<dev>/size, whatever the
+	// logical block size of the device
+	SYSFS_SECTOR_SIZE = 512.0
+	// KIBIBYTE is the unit of the sysfs attributes suffixed with "_kb"
+	KIBIBYTE = 1024.0
+)
+
+// blockQueueAttributes are the sysfs attributes, relative to /sys/block/<dev>,
+// read for each Ondat device along with the factor turning them into base
+// units. Order MUST match the metrics of the BlockQueueCollector.
+var blockQueueAttributes = []struct {
+	file  string
+	scale float64
+}{
+	{"size", SYSFS_SECTOR_SIZE},
+	{"ro", 1},
+	{"queue/logical_block_size", 1},
+	{"queue/physical_block_size", 1},
+	{"queue/rotational", 1},
+	{"queue/nr_requests", 1},
+	{"queue/max_sectors_kb", KIBIBYTE},
+	{"queue/read_ahead_kb", KIBIBYTE},
+	{"queue/discard_granularity", 1},
+}
+
+// BlockQueueCollector gathers the geometry and request queue settings of the
+// Ondat devices from sysfs.
+type BlockQueueCollector struct {
+	scheduler Metric
+
+	// content order MUST match blockQueueAttributes
+	metrics []Metric
+}
+
+func NewBlockQueueCollector() BlockQueueCollector {
+	return BlockQueueCollector{
+		scheduler: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "queue_scheduler_info"),
+			"The active I/O scheduler of the device.",
+			append(pvcLabels, "scheduler"),
+			prometheus.GaugeValue,
+		),
+		metrics: []Metric{
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "size_bytes"),
+				"Size of the device in bytes.",
+				pvcLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "read_only"),
+				"Whether the device is read-only.",
+				pvcLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "logical_block_size_bytes"),
+				"The smallest unit the device can address in bytes.",
+				pvcLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "physical_block_size_bytes"),
+				"The smallest unit the device can write without a read-modify-write in bytes.",
+				pvcLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "rotational"),
+				"Whether the device is considered a rotational one by the kernel.",
+				pvcLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "queue_nr_requests"),
+				"The maximum number of requests queued for the device.",
+				pvcLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "queue_max_request_bytes"),
+				"The maximum size of a request to the device in bytes.",
+				pvcLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "queue_read_ahead_bytes"),
+				"The maximum size of read-ahead for the device in bytes.",
+				pvcLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "queue_discard_granularity_bytes"),
+				"The size of the internal allocation unit of the device for discards in bytes, 0 when discards aren't supported.",
+				pvcLabels,
+				prometheus.GaugeValue,
+			),
+		},
+	}
+}
+
+func (c BlockQueueCollector) Name() string {
+	return BLOCK_QUEUE_COLLECTOR_NAME
+}
+
+func (c BlockQueueCollector) collectsDevices() {}
+
+func (c BlockQueueCollector) Metrics() []Metric {
+	return append([]Metric{c.scheduler}, c.metrics...)
+}
+
+func (c BlockQueueCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheus.Metric, ondatVolumes []*Volume) error {
+	log.Debug("starting block queue metrics collector")
+	log = log.With("collector", BLOCK_QUEUE_COLLECTOR_NAME)
+
+	if len(ondatVolumes) == 0 {
+		log.Debug("no Ondat volumes, metrics collector finished early")
+		return nil
+	}
+
+	for _, localVol := range ondatVolumes {
+		if localVol.Major == 0 && localVol.Minor == 0 {
+			// no block device for this volume on the node
+			continue
+		}
+		logScope := log.With("pvc", localVol.Labels.PVC, "pvc_namespace", localVol.Labels.PVCNamespace)
+
+		device, err := GetBlockDeviceName(localVol.Major, localVol.Minor)
+		if err != nil {
+			logScope.Errorw("error getting device name", "major", localVol.Major, "minor", localVol.Minor, "error", err)
+			continue
+		}
+		logScope = logScope.With("device", device)
+
+		data, err := readHostFile("/sys/block/" + device + "/queue/scheduler")
+		if err != nil {
+			logScope.Errorw("error reading device scheduler", "error", err)
+		} else {
+			metric, err := prometheus.NewConstMetric(c.scheduler.desc, c.scheduler.valueType, 1, localVol.Labels.PVC, localVol.Labels.PVCNamespace, activeScheduler(string(data)))
+			if err != nil {
+				logScope.Errorw("encountered error while building metric", "metric", c.scheduler.desc.String(), "error", err)
+			} else {
+				ch <- metric
+			}
+		}
+
+		// best effort, older kernels lack some of the attributes
+		for i, attr := range blockQueueAttributes {
+			val, err := readHostUint("/sys/block/" + device + "/" + attr.file)
+			if err != nil {
+				logScope.Debugw("error reading device attribute", "attribute", attr.file, "error", err)
+				continue
+			}
+
+			metric, err := prometheus.NewConstMetric(c.metrics[i].desc, c.metrics[i].valueType, float64(val)*attr.scale, localVol.Labels.PVC, localVol.Labels.PVCNamespace)
+			if err != nil {
+				logScope.Errorw("encountered error while building metric", "metric", c.metrics[i].desc.String(), "error", err)
+				continue
+			}
+			ch <- metric
+		}
+	}
+
+	log.Debug("finished metrics collector")
+	return nil
+}
+
+// activeScheduler returns the scheduler in use from the content of
+// /sys/block/<dev>/queue/scheduler, which lists the available ones with the
+// active one in brackets, e.g. "mq-deadline kyber [bfq] none".
+func activeScheduler(schedulers string) string {
+	fields := strings.Fields(schedulers)
+	for _, s := range fields {
+		if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+			return strings.Trim(s, "[]")
+		}
+	}
+	// devices without a queue only list "none"
+	if len(fields) == 1 {
+		return fields[0]
+	}
+	return "unknown"
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveScheduler(t *testing.T) {
+	for schedulers, expected := range map[string]string{
+		"[mq-deadline] kyber bfq none\n": "mq-deadline",
+		"mq-deadline kyber [bfq] none":   "bfq",
+		"[none] mq-deadline":             "none",
+		"none\n":                         "none",
+		"noop deadline cfq":              "unknown",
+		"":                               "unknown",
+	} {
+		require.Equal(t, expected, activeScheduler(schedulers), schedulers)
+	}
+}
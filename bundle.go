@@ -0,0 +1,410 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+// A support bundle is a gzipped tarball of all the host inputs the collectors
+// read on a node. Its layout is the same as the fixture hosts under
+// testdata/hosts, so an extracted bundle can be used as a test case as is.
+const (
+	// BUNDLE_ROOT_DIR holds the host files, at their path on the host
+	BUNDLE_ROOT_DIR = "root"
+	// BUNDLE_VOLUMES_LS_FILE holds the "ls -l" output of the Ondat block
+	// devices directory, as device nodes can't be archived
+	BUNDLE_VOLUMES_LS_FILE = "volumes.ls"
+	// BUNDLE_STATFS_FILE holds the statfs() results keyed by mount point
+	BUNDLE_STATFS_FILE = "statfs.json"
+	// BUNDLE_METRICS_FILE holds the metrics exposed when the bundle was
+	// captured
+	BUNDLE_METRICS_FILE = "metrics.golden"
+
+	// BUNDLE_MAX_FILE_SIZE caps the size of a single file extracted from a
+	// bundle
+	BUNDLE_MAX_FILE_SIZE = 64 << 20
+)
+
+var replayFlag = flag.String("replay", "",
+	"Serve metrics from the host inputs recorded in the given support bundle instead of the node's. "+
+		"See the capture subcommand.")
+
+// statfsResult is the recorded outcome of a statfs() call. A non-zero Errno
+// makes the call fail with that error.
+type statfsResult struct {
+	Type   int64  `json:"type"`
+	Bsize  int64  `json:"bsize"`
+	Blocks uint64 `json:"blocks"`
+	Bfree  uint64 `json:"bfree"`
+	Bavail uint64 `json:"bavail"`
+	Files  uint64 `json:"files"`
+	Ffree  uint64 `json:"ffree"`
+	Errno  int    `json:"errno,omitempty"`
+}
+
+// bundleRecorder accumulates the host inputs read by the collectors. Safe for
+// concurrent use as collectors run in parallel.
+type bundleRecorder struct {
+	mtx sync.Mutex
+
+	files          map[string][]byte
+	dirs           map[string]struct{}
+	volumesListing []string
+	statfs         map[string]statfsResult
+}
+
+func newBundleRecorder() *bundleRecorder {
+	return &bundleRecorder{
+		files:  map[string][]byte{},
+		dirs:   map[string]struct{}{},
+		statfs: map[string]statfsResult{},
+	}
+}
+
+func (r *bundleRecorder) addFile(path string, content []byte) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.files[filepath.Clean(path)] = content
+}
+
+func (r *bundleRecorder) addDir(path string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.dirs[filepath.Clean(path)] = struct{}{}
+}
+
+func (r *bundleRecorder) addVolumesListing(lines []string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.volumesListing = lines
+}
+
+func (r *bundleRecorder) addStatfs(path string, buf *unix.Statfs_t, err error) {
+	res := statfsResult{}
+	if err != nil {
+		errno := unix.EIO
+		errors.As(err, &errno)
+		res.Errno = int(errno)
+	} else {
+		res = statfsResult{
+			Type:   int64(buf.Type),
+			Bsize:  int64(buf.Bsize),
+			Blocks: buf.Blocks,
+			Bfree:  buf.Bfree,
+			Bavail: buf.Bavail,
+			Files:  buf.Files,
+			Ffree:  buf.Ffree,
+		}
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.statfs[path] = res
+}
+
+// write writes everything recorded so far, along with the given metrics
+// exposition, as a gzipped tarball.
+func (r *bundleRecorder) write(w io.Writer, metrics []byte) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	statfsContent, err := json.MarshalIndent(r.statfs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// every directory leading to a recorded file is archived too
+	dirs := map[string]struct{}{}
+	for dir := range r.dirs {
+		for ; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+			dirs[dir] = struct{}{}
+		}
+	}
+	for file := range r.files {
+		for dir := filepath.Dir(file); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+			dirs[dir] = struct{}{}
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: BUNDLE_ROOT_DIR + "/", Mode: 0755, ModTime: now}); err != nil {
+		return err
+	}
+	dirNames := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		dirNames = append(dirNames, dir)
+	}
+	sort.Strings(dirNames)
+	for _, dir := range dirNames {
+		hdr := &tar.Header{Typeflag: tar.TypeDir, Name: path.Join(BUNDLE_ROOT_DIR, dir) + "/", Mode: 0755, ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+
+	files := map[string][]byte{
+		BUNDLE_VOLUMES_LS_FILE: []byte(strings.Join(r.volumesListing, "\n")),
+		BUNDLE_STATFS_FILE:     append(statfsContent, '\n'),
+		BUNDLE_METRICS_FILE:    metrics,
+	}
+	for file, content := range r.files {
+		files[path.Join(BUNDLE_ROOT_DIR, file)] = content
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extractBundle extracts the support bundle at path into the dst directory.
+func extractBundle(path, dst string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("could not read bundle %s: %w", path, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read bundle %s: %w", path, err)
+		}
+
+		// bundles come from other nodes, never write outside of dst
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path %q in bundle %s", hdr.Name, path)
+		}
+		target := filepath.Join(dst, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if hdr.Size > BUNDLE_MAX_FILE_SIZE {
+				return fmt.Errorf("file %q in bundle %s is too big", hdr.Name, path)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			content, err := ioutil.ReadAll(io.LimitReader(tr, BUNDLE_MAX_FILE_SIZE))
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(target, content, 0644); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected type of entry %q in bundle %s", hdr.Name, path)
+		}
+	}
+}
+
+// useHost points the collectors at the host inputs found in dir, as laid out
+// in a support bundle. The returned function restores the previous host.
+func useHost(dir string) (func(), error) {
+	ls, err := ioutil.ReadFile(filepath.Join(dir, BUNDLE_VOLUMES_LS_FILE))
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, BUNDLE_STATFS_FILE))
+	if err != nil {
+		return nil, err
+	}
+	results := map[string]statfsResult{}
+	if err := json.Unmarshal(content, &results); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", BUNDLE_STATFS_FILE, err)
+	}
+
+	oldRoot, oldStatfs, oldList := hostRoot, statfs, listOndatVolumes
+	restore := func() {
+		hostRoot, statfs, listOndatVolumes = oldRoot, oldStatfs, oldList
+	}
+
+	hostRoot = filepath.Join(dir, BUNDLE_ROOT_DIR)
+	listOndatVolumes = func() ([]string, error) {
+		return strings.Split(string(ls), "\n"), nil
+	}
+	statfs = func(path string, buf *unix.Statfs_t) error {
+		res, ok := results[path]
+		if !ok {
+			return unix.ENOENT
+		}
+		if res.Errno != 0 {
+			return unix.Errno(res.Errno)
+		}
+		buf.Type = res.Type
+		buf.Bsize = res.Bsize
+		buf.Blocks = res.Blocks
+		buf.Bfree = res.Bfree
+		buf.Bavail = res.Bavail
+		buf.Files = res.Files
+		buf.Ffree = res.Ffree
+		return nil
+	}
+
+	return restore, nil
+}
+
+// replayBundle extracts the given support bundle in a temporary directory and
+// points the collectors at it. The returned function removes the directory.
+func replayBundle(path string) (func(), error) {
+	dir, err := ioutil.TempDir("", "metrics-exporter-replay-")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	if err := extractBundle(path, dir); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if _, err := useHost(dir); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("invalid bundle %s: %w", path, err)
+	}
+	return cleanup, nil
+}
+
+// gatherExposition runs the given collector and returns the metrics in the
+// text exposition format. The scrape duration is dropped as it is never the
+// same between two runs.
+func gatherExposition(c prometheus.Collector) ([]byte, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		return nil, err
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtText)
+	for _, mf := range families {
+		if mf.GetName() == prometheus.BuildFQName(ONDAT_NAMESPACE, SCRAPE_SUBSYSTEM, "collector_duration_seconds") {
+			continue
+		}
+		if err := enc.Encode(mf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// runCapture implements the capture subcommand. It runs every collector once,
+// recording all the host inputs they read, and writes them in a support
+// bundle that can be replayed offline with the -replay flag.
+func runCapture(args []string) int {
+	flags := flag.NewFlagSet("capture", flag.ExitOnError)
+	output := flags.String("o", "bundle.tar.gz", "Path of the support bundle to write, \"-\" for the standard output.")
+	logLevel := flags.String("log-level", "info", "Verbosity of log messages. Accepts go.uber.org/zap log levels.")
+	timeout := flags.Duration("timeout", 30*time.Second,
+		"Time to wait for the collectors. The bundle is written with what was recorded so far when reached.")
+	_ = flags.Parse(args)
+
+	logger, err := newLogger(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build logger: %s\n", err)
+		return 1
+	}
+	defer func() { _ = logger.Sync() }()
+	log := logger.Sugar()
+
+	recorder = newBundleRecorder()
+
+	type gathered struct {
+		metrics []byte
+		err     error
+	}
+	results := make(chan gathered, 1)
+
+	// every collector is run, whatever the config, to capture all the inputs
+	// a support case may need
+	go func() {
+		metrics, err := gatherExposition(NewCollectorGroup(log, GetEnabledMetricsCollectors(zap.NewNop().Sugar(), configondatv1.MetricsExporterConfigSpec{})))
+		results <- gathered{metrics: metrics, err: err}
+	}()
+
+	var metrics []byte
+	select {
+	case res := <-results:
+		if res.err != nil {
+			log.Errorw("failed to gather metrics, the bundle won't have them", "error", res.err)
+		}
+		metrics = res.metrics
+	case <-time.After(*timeout):
+		log.Errorw("timed out waiting for the collectors, writing the bundle with what was recorded so far", "timeout", *timeout)
+	}
+
+	file := os.Stdout
+	if *output != "-" {
+		file, err = os.Create(*output)
+		if err != nil {
+			log.Errorw("failed to create bundle", "path", *output, "error", err)
+			return 1
+		}
+		defer file.Close()
+	}
+
+	if err := recorder.write(file, metrics); err != nil {
+		log.Errorw("failed to write bundle", "path", *output, "error", err)
+		return 1
+	}
+
+	log.Infow("support bundle written", "path", *output)
+	return 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+// TestCaptureReplay captures a bundle from each fixture host and checks that
+// replaying it exposes the very same metrics.
+func TestCaptureReplay(t *testing.T) {
+	for _, dir := range fixtureHosts(t) {
+		var dir = dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			useFixtureHost(t, dir)
+			log := zap.NewNop().Sugar()
+
+			recorder = newBundleRecorder()
+			defer func() { recorder = nil }()
+
+			captured, err := gatherExposition(NewCollectorGroup(log, GetEnabledMetricsCollectors(log, configondatv1.MetricsExporterConfigSpec{})))
+			require.NoError(t, err)
+
+			bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+			file, err := os.Create(bundle)
+			require.NoError(t, err)
+			require.NoError(t, recorder.write(file, captured))
+			require.NoError(t, file.Close())
+			recorder = nil
+
+			cleanup, err := replayBundle(bundle)
+			require.NoError(t, err)
+			defer cleanup()
+
+			replayed, err := gatherExposition(NewCollectorGroup(log, GetEnabledMetricsCollectors(log, configondatv1.MetricsExporterConfigSpec{})))
+			require.NoError(t, err)
+			require.Equal(t, string(captured), string(replayed))
+
+			// only what the collectors read is captured
+			extracted := t.TempDir()
+			require.NoError(t, extractBundle(bundle, extracted))
+			_, err = os.Stat(filepath.Join(extracted, BUNDLE_ROOT_DIR, "sys", "block", "sda"))
+			require.True(t, os.IsNotExist(err), "unexpected capture of a non Ondat device")
+		})
+	}
+}
+
+func TestExtractBundleRejectsEscapingPaths(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	content := []byte("oops")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../escaped", Mode: 0644, Size: int64(len(content))}))
+	_, err := tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "bundle.tar.gz")
+	require.NoError(t, ioutil.WriteFile(bundle, buf.Bytes(), 0644))
+
+	dst := filepath.Join(dir, "dst")
+	require.Error(t, extractBundle(bundle, dst))
+	_, err = os.Stat(filepath.Join(dir, "escaped"))
+	require.True(t, os.IsNotExist(err))
+}
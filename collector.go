@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"runtime/debug"
 	"sync"
 	"time"
 
@@ -13,9 +15,22 @@ import (
 
 type Collector interface {
 	Name() string
+	// Metrics returns every metric the collector may expose
+	Metrics() []Metric
 	Collect(log *zap.SugaredLogger, ch chan<- prometheus.Metric, ondatVolumes []*Volume) error
 }
 
+// deviceCollector is implemented by the collectors of the block devices of the
+// Ondat volumes, which fail when their major and minor numbers can't be found.
+type deviceCollector interface {
+	collectsDevices()
+}
+
+// closer is implemented by the collectors working in the background.
+type closer interface {
+	close()
+}
+
 type CollectorGroup struct {
 	log *zap.SugaredLogger
 
@@ -30,8 +45,28 @@ func NewCollectorGroup(log *zap.SugaredLogger, c []Collector) CollectorGroup {
 }
 
 func (c CollectorGroup) Describe(ch chan<- *prometheus.Desc) {
-	ch <- scrapeDurationMetric.desc
-	ch <- scrapeSuccessMetric.desc
+	for _, m := range c.Metrics() {
+		ch <- m.desc
+	}
+	for _, collector := range c.collectors {
+		for _, m := range collector.Metrics() {
+			ch <- m.desc
+		}
+	}
+}
+
+// Close stops the collectors working in the background.
+func (c CollectorGroup) Close() {
+	for _, collector := range c.collectors {
+		if cl, ok := collector.(closer); ok {
+			cl.close()
+		}
+	}
+}
+
+// Metrics returns the metrics shared between all collectors.
+func (c CollectorGroup) Metrics() []Metric {
+	return []Metric{scrapeDurationMetric, scrapeSuccessMetric}
 }
 
 // Collect gathers all the metrics and reports back on both the process itself
@@ -45,26 +80,39 @@ func (c CollectorGroup) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
+	// the major and minor numbers are resolved once per scrape, the collectors
+	// gather metrics in parallel and only read the volumes
+	var numbersErr error
+	if len(ondatVolumes) > 0 {
+		numbersErr = ExtractOndatVolumesNumbers(c.log, ondatVolumes)
+		if numbersErr != nil {
+			c.log.Errorw("error getting Ondat volumes major and minor numbers", "error", numbersErr)
+		}
+	}
+
 	wg := sync.WaitGroup{}
 	wg.Add(len(c.collectors))
 	for _, collector := range c.collectors {
-		// each collector gathers metrics is parallel
 		go func(collector Collector) {
 			log := c.log.With("req_id", uuid.New())
-			execute(log, collector, ch, ondatVolumes)
+			execute(log, collector, ch, ondatVolumes, numbersErr)
 			wg.Done()
 		}(collector)
 	}
 	wg.Wait()
 }
 
-func execute(log *zap.SugaredLogger, c Collector, ch chan<- prometheus.Metric, ondatVolumes []*Volume) {
+func execute(log *zap.SugaredLogger, c Collector, ch chan<- prometheus.Metric, ondatVolumes []*Volume, numbersErr error) {
 	timeStart := time.Now()
 
 	// best effort
 	// even if there's an error processing a specific Volume or disk
 	// all those that succeed still get reported
-	err := c.Collect(log, ch, ondatVolumes)
+	err := collect(log, c, ch, ondatVolumes)
+	if _, ok := c.(deviceCollector); ok && err == nil && numbersErr != nil {
+		// none of the devices could be collected
+		err = numbersErr
+	}
 
 	duration := time.Since(timeStart)
 	ch <- prometheus.MustNewConstMetric(scrapeDurationMetric.desc, scrapeDurationMetric.valueType, duration.Seconds(), c.Name())
@@ -80,21 +128,71 @@ func execute(log *zap.SugaredLogger, c Collector, ch chan<- prometheus.Metric, o
 	ch <- prometheus.MustNewConstMetric(scrapeSuccessMetric.desc, scrapeSuccessMetric.valueType, success, c.Name())
 }
 
+// collect runs the given collector, turning a panic into an error so that a
+// bug triggered by unexpected host content only fails that collector instead of
+// the whole exporter.
+func collect(log *zap.SugaredLogger, c Collector, ch chan<- prometheus.Metric, ondatVolumes []*Volume) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorw("collector panicked", "collector", c.Name(), "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("collector %s panicked: %v", c.Name(), r)
+		}
+	}()
+
+	return c.Collect(log, ch, ondatVolumes)
+}
+
+// GetEnabledMetricsCollectors returns the collectors enabled by the given
+// config, fit for the kernel of the host.
 func GetEnabledMetricsCollectors(
 	log *zap.SugaredLogger,
-	disabled []configondatv1.MetricsExporterCollector,
+	cfg configondatv1.MetricsExporterConfigSpec,
+) []Collector {
+	return newMetricsCollectors(log, cfg, detectKernelFeatures(log))
+}
+
+func newMetricsCollectors(
+	log *zap.SugaredLogger,
+	cfg configondatv1.MetricsExporterConfigSpec,
+	features kernelFeatures,
 ) []Collector {
 	var metricsCollectors []Collector
 	for name, collectorFactory := range map[configondatv1.MetricsExporterCollector](func() Collector){
-		configondatv1.MetricsExporterCollectorDiskStats:  func() Collector { return NewDiskStatsCollector() },
-		configondatv1.MetricsExporterCollectorFileSystem: func() Collector { return NewFileSystemCollector() },
+		configondatv1.MetricsExporterCollectorDiskStats:  func() Collector { return NewDiskStatsCollector(cfg, features) },
+		configondatv1.MetricsExporterCollectorFileSystem: func() Collector { return NewFileSystemCollector(cfg) },
+		configondatv1.MetricsExporterCollectorBlockQueue: func() Collector { return NewBlockQueueCollector() },
+		configondatv1.MetricsExporterCollectorExt4:       func() Collector { return NewExt4Collector() },
+		configondatv1.MetricsExporterCollectorXFS:        func() Collector { return NewXFSCollector() },
+		configondatv1.MetricsExporterCollectorBDI:        func() Collector { return NewBDICollector() },
 	} {
-		if IsCollectorDisabled(disabled, name) {
+		if IsCollectorDisabled(cfg.DisabledCollectors, name) {
 			log.Infof("disabling %s collector", name)
 			continue
 		}
+		if name == configondatv1.MetricsExporterCollectorBDI && !features.bdiStats {
+			log.Infof("disabling %s collector, no backing device stats on the host", name)
+			continue
+		}
 		metricsCollectors = append(metricsCollectors, collectorFactory())
 	}
+
+	// the sampler runs in the background, it is opt-in
+	if cfg.SamplingInterval > 0 {
+		if IsCollectorDisabled(cfg.DisabledCollectors, configondatv1.MetricsExporterCollectorSampler) {
+			log.Infof("disabling %s collector", configondatv1.MetricsExporterCollectorSampler)
+		} else {
+			metricsCollectors = append(metricsCollectors, NewSamplerCollector(log, cfg))
+		}
+	}
+
+	// quotactl() is seldom needed, it is opt-in
+	if cfg.ProjectQuotas {
+		if IsCollectorDisabled(cfg.DisabledCollectors, configondatv1.MetricsExporterCollectorQuota) {
+			log.Infof("disabling %s collector", configondatv1.MetricsExporterCollectorQuota)
+		} else {
+			metricsCollectors = append(metricsCollectors, NewQuotaCollector(cfg))
+		}
+	}
 	return metricsCollectors
 }
 
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
@@ -11,9 +14,12 @@ import (
 
 func TestGetEnabledMetricsCollectors(t *testing.T) {
 	tests := []struct {
-		name            string
-		disable         []configondatv1.MetricsExporterCollector
-		expectedEnabled []string
+		name             string
+		disable          []configondatv1.MetricsExporterCollector
+		samplingInterval int
+		projectQuotas    bool
+		noBDIStats       bool
+		expectedEnabled  []string
 	}{
 		{
 			name:    "all enabled",
@@ -21,6 +27,10 @@ func TestGetEnabledMetricsCollectors(t *testing.T) {
 			expectedEnabled: []string{
 				"diskstats",
 				"filesystem",
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
 			},
 		},
 
@@ -29,6 +39,10 @@ func TestGetEnabledMetricsCollectors(t *testing.T) {
 			disable: []configondatv1.MetricsExporterCollector{configondatv1.MetricsExporterCollectorFileSystem},
 			expectedEnabled: []string{
 				"diskstats",
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
 			},
 		},
 
@@ -37,6 +51,10 @@ func TestGetEnabledMetricsCollectors(t *testing.T) {
 			disable: []configondatv1.MetricsExporterCollector{configondatv1.MetricsExporterCollectorDiskStats},
 			expectedEnabled: []string{
 				"filesystem",
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
 			},
 		},
 
@@ -46,7 +64,12 @@ func TestGetEnabledMetricsCollectors(t *testing.T) {
 				configondatv1.MetricsExporterCollectorFileSystem,
 				configondatv1.MetricsExporterCollectorDiskStats,
 			},
-			expectedEnabled: []string{},
+			expectedEnabled: []string{
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
+			},
 		},
 
 		{
@@ -55,6 +78,24 @@ func TestGetEnabledMetricsCollectors(t *testing.T) {
 				configondatv1.MetricsExporterCollectorDiskStats,
 				configondatv1.MetricsExporterCollectorFileSystem,
 			},
+			expectedEnabled: []string{
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
+			},
+		},
+
+		{
+			name: "disable all",
+			disable: []configondatv1.MetricsExporterCollector{
+				configondatv1.MetricsExporterCollectorDiskStats,
+				configondatv1.MetricsExporterCollectorFileSystem,
+				configondatv1.MetricsExporterCollectorBlockQueue,
+				configondatv1.MetricsExporterCollectorExt4,
+				configondatv1.MetricsExporterCollectorXFS,
+				configondatv1.MetricsExporterCollectorBDI,
+			},
 			expectedEnabled: []string{},
 		},
 
@@ -66,6 +107,78 @@ func TestGetEnabledMetricsCollectors(t *testing.T) {
 			},
 			expectedEnabled: []string{
 				"diskstats",
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
+			},
+		},
+
+		{
+			name:             "enable sampler",
+			samplingInterval: 1000,
+			expectedEnabled: []string{
+				"diskstats",
+				"filesystem",
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
+				"sampler",
+			},
+		},
+
+		{
+			name:             "disable enabled sampler",
+			disable:          []configondatv1.MetricsExporterCollector{configondatv1.MetricsExporterCollectorSampler},
+			samplingInterval: 1000,
+			expectedEnabled: []string{
+				"diskstats",
+				"filesystem",
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
+			},
+		},
+
+		{
+			name:       "no bdi stats",
+			noBDIStats: true,
+			expectedEnabled: []string{
+				"diskstats",
+				"filesystem",
+				"blockqueue",
+				"ext4",
+				"xfs",
+			},
+		},
+
+		{
+			name:          "enable quota",
+			projectQuotas: true,
+			expectedEnabled: []string{
+				"diskstats",
+				"filesystem",
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
+				"quota",
+			},
+		},
+
+		{
+			name:          "disable enabled quota",
+			disable:       []configondatv1.MetricsExporterCollector{configondatv1.MetricsExporterCollectorQuota},
+			projectQuotas: true,
+			expectedEnabled: []string{
+				"diskstats",
+				"filesystem",
+				"blockqueue",
+				"ext4",
+				"xfs",
+				"bdi",
 			},
 		},
 	}
@@ -79,7 +192,9 @@ func TestGetEnabledMetricsCollectors(t *testing.T) {
 			logger, _ := loggerConfig.Build()
 			log := logger.Sugar()
 
-			collectors := GetEnabledMetricsCollectors(log, tt.disable)
+			features := allKernelFeatures
+			features.bdiStats = !tt.noBDIStats
+			collectors := newMetricsCollectors(log, configondatv1.MetricsExporterConfigSpec{DisabledCollectors: tt.disable, SamplingInterval: tt.samplingInterval, ProjectQuotas: tt.projectQuotas}, features)
 			names := make([]string, 0, len(collectors))
 			for _, c := range collectors {
 				names = append(names, c.Name())
@@ -88,3 +203,40 @@ func TestGetEnabledMetricsCollectors(t *testing.T) {
 		})
 	}
 }
+
+type fakeCollector struct{ name string }
+
+func (c fakeCollector) Name() string      { return c.name }
+func (c fakeCollector) Metrics() []Metric { return nil }
+func (c fakeCollector) Collect(*zap.SugaredLogger, chan<- prometheus.Metric, []*Volume) error {
+	return nil
+}
+
+type fakeDeviceCollector struct{ fakeCollector }
+
+func (c fakeDeviceCollector) collectsDevices() {}
+
+func TestExecuteDeviceNumbersError(t *testing.T) {
+	log := zap.NewNop().Sugar()
+
+	success := func(c Collector) float64 {
+		ch := make(chan prometheus.Metric, 2)
+		execute(log, c, ch, nil, errors.New("no volumes directory"))
+		close(ch)
+
+		for metric := range ch {
+			if metric.Desc() != scrapeSuccessMetric.desc {
+				continue
+			}
+			var m dto.Metric
+			require.NoError(t, metric.Write(&m))
+			return m.GetGauge().GetValue()
+		}
+		t.Fatal("no scrape success metric")
+		return 0
+	}
+
+	// only the collectors of the devices need their numbers
+	require.Equal(t, 1.0, success(fakeCollector{name: "mounts"}))
+	require.Equal(t, 0.0, success(fakeDeviceCollector{fakeCollector{name: "devices"}}))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+)
+
+// deviceGenerations tracks the device of each Ondat volume across scrapes. A
+// volume detached then attached again to the node gets a new device, whose
+// counters start from zero, with different major and minor numbers. Each new
+// device of a volume is a new generation.
+type deviceGenerations struct {
+	mtx     sync.Mutex
+	volumes map[string]*volumeDevice
+}
+
+// volumeDevice is the current device of a volume.
+type volumeDevice struct {
+	device     deviceNumber
+	generation int
+
+	// last holds the last counter values read from the device, offsets the
+	// sum of the last values of all the previous devices of the volume
+	last    []float64
+	offsets []float64
+}
+
+func newDeviceGenerations() *deviceGenerations {
+	return &deviceGenerations{volumes: map[string]*volumeDevice{}}
+}
+
+// observe records the counter values read from the given device of the given
+// volume and returns the generation of the device. The device is a new one
+// when its major and minor numbers changed. Counters going backward on the same
+// device are not a new one: concurrent scrapes may observe their reads out of
+// order. When monotonic is set, values are offset in place so that they keep
+// increasing across generations. Only the values whose isCounter is set are
+// checked and offset.
+func (g *deviceGenerations) observe(volumeID string, device deviceNumber, values []float64, isCounter []bool, monotonic bool) int {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	vol, ok := g.volumes[volumeID]
+	if !ok {
+		vol = &volumeDevice{device: device, generation: 1, offsets: make([]float64, len(values))}
+		g.volumes[volumeID] = vol
+	} else if vol.device != device {
+		vol.device = device
+		vol.generation++
+		for i := range vol.offsets {
+			if i < len(vol.last) && isCounter[i] {
+				vol.offsets[i] += vol.last[i]
+			}
+		}
+		vol.last = vol.last[:0]
+	}
+
+	// the last values of the counters are the highest seen, those of an older
+	// read are stale
+	for i := range values {
+		if i >= len(vol.last) {
+			vol.last = append(vol.last, values[i])
+		} else if !isCounter[i] || values[i] > vol.last[i] {
+			vol.last[i] = values[i]
+		}
+	}
+	if monotonic {
+		for i := range values {
+			if i < len(vol.offsets) && isCounter[i] {
+				values[i] += vol.offsets[i]
+			}
+		}
+	}
+	return vol.generation
+}
+
+// forget stops tracking the volumes not in the given set.
+func (g *deviceGenerations) forget(volumeIDs map[string]struct{}) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	for volumeID := range g.volumes {
+		if _, ok := volumeIDs[volumeID]; !ok {
+			delete(g.volumes, volumeID)
+		}
+	}
+}
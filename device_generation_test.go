@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceGenerations(t *testing.T) {
+	const volID = "c3561d79-459f-4e5d-b5bb-f71ae7b38672"
+	sdc := deviceNumber{major: 8, minor: 32}
+	sdd := deviceNumber{major: 8, minor: 48}
+	// completed reads, then I/Os in progress
+	isCounter := []bool{true, false}
+
+	tests := []struct {
+		name      string
+		monotonic bool
+
+		expectedValues      [][]float64
+		expectedGenerations []int
+	}{
+		{
+			name:                "raw counters",
+			expectedValues:      [][]float64{{10, 2}, {15, 1}, {14, 2}, {3, 0}, {4, 0}},
+			expectedGenerations: []int{1, 1, 1, 2, 2},
+		},
+		{
+			name:                "monotonic counters",
+			monotonic:           true,
+			expectedValues:      [][]float64{{10, 2}, {15, 1}, {14, 2}, {18, 0}, {19, 0}},
+			expectedGenerations: []int{1, 1, 1, 2, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newDeviceGenerations()
+			for i, scrape := range []struct {
+				device deviceNumber
+				values []float64
+			}{
+				{sdc, []float64{10, 2}},
+				{sdc, []float64{15, 1}},
+				// read by a concurrent scrape before the previous one
+				{sdc, []float64{14, 2}},
+				// re-attached with new numbers
+				{sdd, []float64{3, 0}},
+				{sdd, []float64{4, 0}},
+			} {
+				generation := g.observe(volID, scrape.device, scrape.values, isCounter, tt.monotonic)
+				require.Equal(t, tt.expectedGenerations[i], generation, "scrape %d", i)
+				require.Equal(t, tt.expectedValues[i], scrape.values, "scrape %d", i)
+			}
+		})
+	}
+}
+
+func TestDeviceGenerationsForget(t *testing.T) {
+	g := newDeviceGenerations()
+	sdc := deviceNumber{major: 8, minor: 32}
+	isCounter := []bool{true}
+
+	g.observe("a", sdc, []float64{10}, isCounter, true)
+	g.observe("b", deviceNumber{major: 8, minor: 48}, []float64{1}, isCounter, true)
+	g.forget(map[string]struct{}{"b": {}})
+	require.Len(t, g.volumes, 1)
+
+	// a deleted volume starts over
+	require.Equal(t, 1, g.observe("a", deviceNumber{major: 8, minor: 64}, []float64{2}, isCounter, true))
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// volumeDiagnosis is the volume to device to mount chain of an Ondat volume,
+// as followed by the collectors.
+type volumeDiagnosis struct {
+	VolumeID     string `json:"volumeID"`
+	PVC          string `json:"pvc"`
+	PVCNamespace string `json:"pvcNamespace"`
+
+	DeviceNode string `json:"deviceNode"`
+	// Major and Minor are 0 when no block device was found for the volume
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	// DiskstatsDevice is the kernel name of the device from its
+	// /proc/diskstats row, empty when no row was found
+	DiskstatsDevice string `json:"diskstatsDevice"`
+	// StackedDevices are the devices stacked on top of the volume's device,
+	// e.g. "dm-0 (crypt)"
+	StackedDevices []string `json:"stackedDevices"`
+
+	Mounts []mountDiagnosis `json:"mounts"`
+
+	// Problems lists every gap found in the chain, the diskstats and
+	// filesystem metrics of the volume are incomplete when not empty
+	Problems []string `json:"problems"`
+}
+
+type mountDiagnosis struct {
+	MountPoint string `json:"mountPoint"`
+	FSType     string `json:"fsType"`
+	Options    string `json:"options"`
+
+	// Statfs is a summary of the statfs() call result
+	Statfs string `json:"statfs"`
+	// Stuck is whether statfs() didn't return before the timeout
+	Stuck bool `json:"stuck"`
+}
+
+// diagnose follows the volume to device to mount chain of every Ondat volume
+// found on the host, the same way the collectors do, and reports any gap.
+func diagnose(log *zap.SugaredLogger, statfsTimeout time.Duration) ([]*volumeDiagnosis, error) {
+	vols, err := GetVolumesFromLocalState(log)
+	if err != nil {
+		return nil, fmt.Errorf("could not read Ondat volumes from local state files: %w", err)
+	}
+
+	diags := make([]*volumeDiagnosis, 0, len(vols))
+	byID := make(map[string]*volumeDiagnosis, len(vols))
+	for _, vol := range vols {
+		diag := &volumeDiagnosis{
+			VolumeID:       vol.Master.VolumeID,
+			PVC:            vol.Labels.PVC,
+			PVCNamespace:   vol.Labels.PVCNamespace,
+			DeviceNode:     STOS_VOLUMES_PATH + "/v." + vol.Master.VolumeID,
+			Mounts:         []mountDiagnosis{},
+			StackedDevices: []string{},
+			Problems:       []string{},
+		}
+		if diag.PVC == "" || diag.PVCNamespace == "" {
+			diag.problem("no PVC labels in the volume state file, metrics will have empty pvc labels")
+		}
+		diags = append(diags, diag)
+		byID[diag.VolumeID] = diag
+	}
+
+	stacked := stackedDevices{}
+	if err := ExtractOndatVolumesNumbers(log, vols); err != nil {
+		for _, diag := range diags {
+			diag.problem("could not list Ondat block devices: %s", err)
+		}
+	} else {
+		for i, vol := range vols {
+			diags[i].Major, diags[i].Minor = vol.Major, vol.Minor
+			if vol.Major == 0 && vol.Minor == 0 {
+				diags[i].problem("no block device %s found, no diskstats metrics", diags[i].DeviceNode)
+			}
+		}
+
+		stacked = discoverStackedDevices(log, vols)
+		for _, dev := range stacked {
+			diag := byID[dev.volume.Master.VolumeID]
+			diag.StackedDevices = append(diag.StackedDevices, fmt.Sprintf("%s (%s)", dev.name, dev.layer))
+		}
+		for _, diag := range diags {
+			sort.Strings(diag.StackedDevices)
+		}
+	}
+
+	devices := make(map[deviceNumber]struct{}, len(diags))
+	for _, diag := range diags {
+		if diag.Major != 0 || diag.Minor != 0 {
+			devices[deviceNumber{major: uint32(diag.Major), minor: uint32(diag.Minor)}] = struct{}{}
+		}
+	}
+	diskstats, err := ProcDiskstatsByDevice(devices)
+	if err != nil {
+		for _, diag := range diags {
+			diag.problem("could not read %s: %s", DISKSTATS_PATH, err)
+		}
+	} else {
+		for _, diag := range diags {
+			if diag.Major == 0 && diag.Minor == 0 {
+				continue
+			}
+			if stats, ok := diskstats[deviceNumber{major: uint32(diag.Major), minor: uint32(diag.Minor)}]; ok {
+				diag.DiskstatsDevice = stats.DeviceName
+			} else {
+				diag.problem("no %s row for device %d:%d, no diskstats metrics", DISKSTATS_PATH, diag.Major, diag.Minor)
+			}
+		}
+	}
+
+	mps, err := mountPointDetails(log)
+	if err != nil {
+		for _, diag := range diags {
+			diag.problem("could not read mounts: %s", err)
+		}
+		return diags, nil
+	}
+	for _, labels := range mps {
+		volID, ok := mountedVolumeID(labels.device)
+		if !ok {
+			dev, isStacked := stacked.byMountSource(labels.device)
+			if !isStacked {
+				continue
+			}
+			volID = dev.volume.Master.VolumeID
+		}
+		diag, ok := byID[volID]
+		if !ok {
+			log.Warnw("mounted Ondat volume has no state file", "device", labels.device, "mountpoint", labels.mountPoint)
+			continue
+		}
+
+		mount := mountDiagnosis{
+			MountPoint: labels.mountPoint,
+			FSType:     labels.fsType,
+			Options:    labels.options,
+		}
+		buf, stuck, err := statfsWithTimeout(labels.mountPoint, statfsTimeout)
+		switch {
+		case stuck:
+			mount.Stuck = true
+			mount.Statfs = fmt.Sprintf("no answer after %s", statfsTimeout)
+			diag.problem("mount point %s is stuck, filesystem metrics report a device error", labels.mountPoint)
+		case err != nil:
+			mount.Statfs = err.Error()
+			diag.problem("statfs() on %s failed, filesystem metrics report a device error", labels.mountPoint)
+		default:
+			mount.Statfs = fmt.Sprintf("ok, %d of %d bytes available", buf.Bavail*uint64(buf.Bsize), buf.Blocks*uint64(buf.Bsize))
+		}
+		diag.Mounts = append(diag.Mounts, mount)
+	}
+
+	for _, diag := range diags {
+		if len(diag.Mounts) == 0 {
+			diag.problem("not mounted on this node, no filesystem metrics")
+		}
+	}
+
+	return diags, nil
+}
+
+func (d *volumeDiagnosis) problem(format string, args ...interface{}) {
+	d.Problems = append(d.Problems, fmt.Sprintf(format, args...))
+}
+
+// statfsWithTimeout calls statfs() on the given mount point, giving up after
+// the timeout. The call itself can't be cancelled and is left behind when
+// stuck.
+func statfsWithTimeout(mountPoint string, timeout time.Duration) (*unix.Statfs_t, bool, error) {
+	type result struct {
+		buf *unix.Statfs_t
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		buf := new(unix.Statfs_t)
+		err := hostStatfs(mountPoint, buf)
+		done <- result{buf: buf, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.buf, false, res.err
+	case <-timer.C:
+		return nil, true, nil
+	}
+}
+
+// writeDiagnosis prints the given diagnosis as a table per volume.
+func writeDiagnosis(w io.Writer, diags []*volumeDiagnosis) error {
+	if len(diags) == 0 {
+		_, err := fmt.Fprintln(w, "no Ondat volumes found in the local state files")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, diag := range diags {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+
+		fmt.Fprintf(tw, "VOLUME\t%s\n", diag.VolumeID)
+		fmt.Fprintf(tw, "PVC\t%s/%s\n", orMissing(diag.PVCNamespace), orMissing(diag.PVC))
+		fmt.Fprintf(tw, "DEVICE NODE\t%s\n", diag.DeviceNode)
+		if diag.Major == 0 && diag.Minor == 0 {
+			fmt.Fprintf(tw, "MAJOR:MINOR\t%s\n", orMissing(""))
+		} else {
+			fmt.Fprintf(tw, "MAJOR:MINOR\t%d:%d\n", diag.Major, diag.Minor)
+		}
+		fmt.Fprintf(tw, "DISKSTATS ROW\t%s\n", orMissing(diag.DiskstatsDevice))
+		for _, dev := range diag.StackedDevices {
+			fmt.Fprintf(tw, "STACKED DEVICE\t%s\n", dev)
+		}
+		if len(diag.Mounts) == 0 {
+			fmt.Fprintf(tw, "MOUNT\t%s\n", orMissing(""))
+		}
+		for _, mount := range diag.Mounts {
+			stuck := "no"
+			if mount.Stuck {
+				stuck = "yes"
+			}
+			fmt.Fprintf(tw, "MOUNT\t%s\n", mount.MountPoint)
+			fmt.Fprintf(tw, "  FSTYPE\t%s (%s)\n", mount.FSType, mount.Options)
+			fmt.Fprintf(tw, "  STATFS\t%s\n", mount.Statfs)
+			fmt.Fprintf(tw, "  STUCK\t%s\n", stuck)
+		}
+		if len(diag.Problems) == 0 {
+			fmt.Fprintf(tw, "PROBLEMS\tnone\n")
+		}
+		for _, problem := range diag.Problems {
+			fmt.Fprintf(tw, "PROBLEM\t%s\n", problem)
+		}
+	}
+	return tw.Flush()
+}
+
+func orMissing(s string) string {
+	if s == "" {
+		return "<missing>"
+	}
+	return s
+}
+
+// runDiagnose implements the diagnose subcommand. It exits with 2 when a gap
+// was found for any volume.
+func runDiagnose(args []string) int {
+	flags := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "Print the diagnosis as JSON.")
+	logLevel := flags.String("log-level", "warn", "Verbosity of log messages. Accepts go.uber.org/zap log levels.")
+	timeout := flags.Duration("timeout", 5*time.Second, "Time after which a statfs() call is considered stuck.")
+	replay := flags.String("replay", "", "Diagnose the host inputs recorded in the given support bundle.")
+	_ = flags.Parse(args)
+
+	logger, err := newLogger(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build logger: %s\n", err)
+		return 1
+	}
+	defer func() { _ = logger.Sync() }()
+	log := logger.Sugar()
+
+	if len(*replay) > 0 {
+		cleanup, err := replayBundle(*replay)
+		if err != nil {
+			log.Errorw("failed to replay support bundle", "bundle", *replay, "error", err)
+			return 1
+		}
+		defer cleanup()
+	}
+
+	diags, err := diagnose(log, *timeout)
+	if err != nil {
+		log.Errorw("failed to diagnose Ondat volumes", "error", err)
+		return 1
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(diags)
+	} else {
+		err = writeDiagnosis(os.Stdout, diags)
+	}
+	if err != nil {
+		log.Errorw("failed to print diagnosis", "error", err)
+		return 1
+	}
+
+	for _, diag := range diags {
+		if len(diag.Problems) > 0 {
+			return 2
+		}
+	}
+	return 0
+}
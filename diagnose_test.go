@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+func TestDiagnose(t *testing.T) {
+	const (
+		volA = "c3561d79-459f-4e5d-b5bb-f71ae7b38672"
+		volB = "78e88095-e690-49be-b0f3-3f735ef084a5"
+		volC = "0b8c7a4e-5d3e-4f0c-9a27-2a1f8f3b6d11"
+	)
+
+	tests := []struct {
+		name string
+		// alter the fixture host before diagnosing
+		setup func()
+
+		expectedDevices  map[string]string
+		expectedProblems map[string][]string
+		expectedStuck    map[string]bool
+	}{
+		{
+			name: "statfs failure",
+
+			expectedDevices: map[string]string{volA: "sdc", volB: "sdd", volC: "sde"},
+			expectedProblems: map[string][]string{
+				volC: {"statfs() on /var/lib/kubelet/pods/1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f/volumes/kubernetes.io~csi/pvc-" + volC + "/mount failed, filesystem metrics report a device error"},
+			},
+		},
+		{
+			name: "missing block device",
+			setup: func() {
+				list := listOndatVolumes
+				listOndatVolumes = func() ([]string, error) {
+					output, err := list()
+					var kept []string
+					for _, line := range output {
+						if !strings.HasSuffix(line, volA) {
+							kept = append(kept, line)
+						}
+					}
+					return kept, err
+				}
+			},
+
+			expectedDevices: map[string]string{volA: "", volB: "sdd", volC: "sde"},
+			expectedProblems: map[string][]string{
+				volA: {"no block device /var/lib/storageos/volumes/v." + volA + " found, no diskstats metrics"},
+				volC: {"statfs() on /var/lib/kubelet/pods/1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f/volumes/kubernetes.io~csi/pvc-" + volC + "/mount failed, filesystem metrics report a device error"},
+			},
+		},
+		{
+			name: "stuck mount",
+			setup: func() {
+				stat := statfs
+				statfs = func(path string, buf *unix.Statfs_t) error {
+					if strings.Contains(path, volB) {
+						time.Sleep(time.Second)
+					}
+					return stat(path, buf)
+				}
+			},
+
+			expectedDevices: map[string]string{volA: "sdc", volB: "sdd", volC: "sde"},
+			expectedProblems: map[string][]string{
+				volB: {"mount point /var/lib/kubelet/pods/7a9c2d10-5b4e-4a8f-8d3c-6e2f1a0b9c8d/volumes/kubernetes.io~csi/pvc-" + volB + "/mount is stuck, filesystem metrics report a device error"},
+				volC: {"statfs() on /var/lib/kubelet/pods/1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f/volumes/kubernetes.io~csi/pvc-" + volC + "/mount failed, filesystem metrics report a device error"},
+			},
+			expectedStuck: map[string]bool{volB: true},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			useFixtureHost(t, filepath.Join("testdata", "hosts", "kernel-5.5"))
+			if tt.setup != nil {
+				tt.setup()
+			}
+
+			diags, err := diagnose(zap.NewNop().Sugar(), 100*time.Millisecond)
+			require.NoError(t, err)
+			require.Len(t, diags, 3)
+
+			// volume A has a global mount and a pod bind mount
+			mounts := map[string]int{volA: 2, volB: 1, volC: 1}
+			for _, diag := range diags {
+				require.Equal(t, tt.expectedDevices[diag.VolumeID], diag.DiskstatsDevice, diag.VolumeID)
+				require.ElementsMatch(t, tt.expectedProblems[diag.VolumeID], diag.Problems, diag.VolumeID)
+				require.Len(t, diag.Mounts, mounts[diag.VolumeID], diag.VolumeID)
+				require.Equal(t, tt.expectedStuck[diag.VolumeID], diag.Mounts[0].Stuck, diag.VolumeID)
+			}
+
+			var out bytes.Buffer
+			require.NoError(t, writeDiagnosis(&out, diags))
+			for _, diag := range diags {
+				require.Contains(t, out.String(), diag.VolumeID)
+			}
+		})
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs/blockdevice"
 	"go.uber.org/zap"
 
 	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
@@ -21,250 +23,443 @@ const (
 type DiskStatsCollector struct {
 	// info of all the scraped PVCs
 	info Metric
+	// source the stats of each PVC were read from
+	source Metric
+	// kernel features deciding which of the metrics are exposed
+	kernelFeatures Metric
+	// reads and writes in flight, from /sys/block/<dev>/inflight
+	inflight Metric
+	// generation of the device of each PVC, incremented on re-attach
+	generation Metric
 
 	// all PVC metrics we gather from diskstats
 	// useful as a standalone variable to iterate over and index match with diskstats's
 	// content order MUST match the columns in the diskstats file
 	metrics []Metric
+	// number of metrics the kernel has the diskstats columns of
+	columns int
+	// whether each of the metrics is a counter
+	isCounter []bool
+	features  kernelFeatures
+
+	// sources to read the stats of a device from, in order of preference
+	sources []configondatv1.MetricsExporterDiskStatsSource
+
+	// devices of the PVCs seen so far, shared by all scrapes
+	generations *deviceGenerations
+	// monotonic counters across the devices of a PVC
+	monotonic bool
 }
 
-func NewDiskStatsCollector() DiskStatsCollector {
-	return DiskStatsCollector{
-		info: Metric{
-			desc: prometheus.NewDesc(prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "info"),
-				"Info of Ondat volumes and devices.",
-				append(pvcLabels, "device", "major", "minor"), nil,
-			),
-			valueType: prometheus.GaugeValue,
-		},
+// NewDiskStatsCollector returns a collector reading the stats of each device
+// from the first of the configured sources that has them, the default ones
+// when none. Only the metrics the given kernel features have columns for are
+// exposed.
+func NewDiskStatsCollector(cfg configondatv1.MetricsExporterConfigSpec, features kernelFeatures) DiskStatsCollector {
+	sources := cfg.DiskStatsSources
+	if len(sources) == 0 {
+		sources = (&configondatv1.MetricsExporterConfig{}).Default().DiskStatsSources
+	}
+
+	c := DiskStatsCollector{
+		sources:     sources,
+		features:    features,
+		generations: newDeviceGenerations(),
+		monotonic:   cfg.MonotonicDiskCounters,
+		generation: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "device_generation"),
+			"The generation of the device of the PVC, incremented each time the volume gets a new device on the node.",
+			pvcLabels,
+			prometheus.GaugeValue,
+		),
+		kernelFeatures: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, EXPORTER_SUBSYSTEM, "kernel_features"),
+			"The optional kernel features the exposed metrics depend on.",
+			[]string{"discard_stats", "flush_stats"},
+			prometheus.GaugeValue,
+		),
+		info: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "info"),
+			"Info of Ondat volumes and devices.",
+			append(diskstatsLabels, "device", "major", "minor"),
+			prometheus.GaugeValue,
+		),
+		source: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "stats_source_info"),
+			"The source the I/O statistics of the device were read from, procfs or sysfs.",
+			append(diskstatsLabels, "source"),
+			prometheus.GaugeValue,
+		),
+		inflight: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "io_inflight"),
+			"The number of I/Os currently in flight by direction, read or write.",
+			append(diskstatsLabels, "direction"),
+			prometheus.GaugeValue,
+		),
 		metrics: []Metric{
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "reads_completed_total"),
-					"The total number of reads completed successfully.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "reads_merged_total"),
-					"The total number of reads merged.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "read_bytes_total"),
-					"The total number of bytes read successfully.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "read_time_seconds_total"),
-					"The total number of seconds spent by all reads.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writes_completed_total"),
-					"The total number of writes completed successfully.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writes_merged_total"),
-					"The number of writes merged.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "written_bytes_total"),
-					"The total number of bytes written successfully.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "write_time_seconds_total"),
-					"This is the total number of seconds spent by all writes.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "io_now"),
-					"The number of I/Os currently in progress.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.GaugeValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "io_time_seconds_total"),
-					"Total seconds spent doing I/Os.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "io_time_weighted_seconds_total"),
-					"The weighted # of seconds spent doing I/Os.",
-					pvcLabels, nil,
-				),
-				valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "discards_completed_total"),
-					"The total number of discards completed successfully.",
-					pvcLabels, nil,
-				), valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "discards_merged_total"),
-					"The total number of discards merged.",
-					pvcLabels, nil,
-				), valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "discarded_sectors_total"),
-					"The total number of sectors discarded successfully.",
-					pvcLabels, nil,
-				), valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "discard_time_seconds_total"),
-					"This is the total number of seconds spent by all discards.",
-					pvcLabels, nil,
-				), valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "flush_requests_total"),
-					"The total number of flush requests completed successfully",
-					pvcLabels, nil,
-				), valueType: prometheus.CounterValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "flush_requests_time_seconds_total"),
-					"This is the total number of seconds spent by all flush requests.",
-					pvcLabels, nil,
-				), valueType: prometheus.CounterValue,
-			},
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "reads_completed_total"),
+				"The total number of reads completed successfully.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "reads_merged_total"),
+				"The total number of reads merged.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "read_bytes_total"),
+				"The total number of bytes read successfully.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "read_time_seconds_total"),
+				"The total number of seconds spent by all reads.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writes_completed_total"),
+				"The total number of writes completed successfully.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "writes_merged_total"),
+				"The number of writes merged.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "written_bytes_total"),
+				"The total number of bytes written successfully.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "write_time_seconds_total"),
+				"This is the total number of seconds spent by all writes.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "io_now"),
+				"The number of I/Os currently in progress.",
+				diskstatsLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "io_time_seconds_total"),
+				"Total seconds spent doing I/Os.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "io_time_weighted_seconds_total"),
+				"The weighted # of seconds spent doing I/Os.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "discards_completed_total"),
+				"The total number of discards completed successfully.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "discards_merged_total"),
+				"The total number of discards merged.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "discarded_sectors_total"),
+				"The total number of sectors discarded successfully.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "discard_time_seconds_total"),
+				"This is the total number of seconds spent by all discards.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "flush_requests_total"),
+				"The total number of flush requests completed successfully",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "flush_requests_time_seconds_total"),
+				"This is the total number of seconds spent by all flush requests.",
+				diskstatsLabels,
+				prometheus.CounterValue,
+			),
 		},
 	}
+
+	// total diskstats column count, less the MajorNumber, MinorNumber and DeviceName
+	c.columns = features.diskstatsColumns - 3
+	if c.columns > len(c.metrics) || c.columns < 0 {
+		c.columns = len(c.metrics)
+	}
+	for _, m := range c.metrics {
+		c.isCounter = append(c.isCounter, m.valueType == prometheus.CounterValue)
+	}
+	return c
 }
 
 func (c DiskStatsCollector) Name() string {
 	return DISKSTATS_COLLECTOR_NAME
 }
 
+func (c DiskStatsCollector) collectsDevices() {}
+
+func (c DiskStatsCollector) Metrics() []Metric {
+	return append([]Metric{c.kernelFeatures, c.info, c.source, c.inflight, c.generation}, c.metrics[:c.columns]...)
+}
+
 func (c DiskStatsCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheus.Metric, ondatVolumes []*Volume) error {
 	log.Debug("starting diskstats metrics collector")
 	log = log.With("collector", DISKSTATS_COLLECTOR_NAME)
 
+	metric, err := prometheus.NewConstMetric(c.kernelFeatures.desc, c.kernelFeatures.valueType, 1.0, strconv.FormatBool(c.features.discardStats()), strconv.FormatBool(c.features.flushStats()))
+	if err != nil {
+		log.Errorw("encountered error while building metric", "metric", c.kernelFeatures.desc.String(), "error", err)
+	} else {
+		ch <- metric
+	}
+
+	// volumes deleted since the last scrape are not tracked anymore
+	volumeIDs := make(map[string]struct{}, len(ondatVolumes))
+	for _, localVol := range ondatVolumes {
+		volumeIDs[localVol.Master.VolumeID] = struct{}{}
+	}
+	c.generations.forget(volumeIDs)
+
 	if len(ondatVolumes) == 0 {
 		log.Debug("no Ondat volumes, metrics collector finished early")
 		return nil
 	}
 
-	err := ExtractOndatVolumesNumbers(log, ondatVolumes)
-	if err != nil {
-		log.Errorw("error getting Ondat volumes major and minor numbers", "error", err)
-		return err
+	// the Ondat devices, then the devices stacked on top of them
+	targets := make([]diskstatsTarget, 0, len(ondatVolumes))
+	for _, localVol := range ondatVolumes {
+		if localVol.Major == 0 && localVol.Minor == 0 {
+			// no block device for this volume on the node
+			continue
+		}
+		targets = append(targets, diskstatsTarget{volume: localVol, major: localVol.Major, minor: localVol.Minor})
+	}
+	for _, dev := range discoverStackedDevices(log, ondatVolumes) {
+		targets = append(targets, diskstatsTarget{volume: dev.volume, major: dev.major, minor: dev.minor, layer: dev.layer, layerDevice: dev.name})
 	}
 
-	diskstats, err := ProcDiskstats()
-	if err != nil {
-		log.Errorw("error reading diskstats", "error", err)
-		return err
+	devices := make(map[deviceNumber]struct{}, len(targets))
+	for _, target := range targets {
+		devices[deviceNumber{major: uint32(target.major), minor: uint32(target.minor)}] = struct{}{}
 	}
+	reader := &deviceStatsReader{devices: devices}
 
-	for _, localVol := range ondatVolumes {
-		logScope := log.With("pvc", localVol.Labels.PVC, "pvc_namespace", localVol.Labels.PVCNamespace)
+	collected := 0
+	for _, target := range targets {
+		localVol := target.volume
+		logScope := log.With("pvc", localVol.Labels.PVC, "pvc_namespace", localVol.Labels.PVCNamespace, "layer", target.layer, "layer_device", target.layerDevice)
+		labels := []string{localVol.Labels.PVC, localVol.Labels.PVCNamespace, target.layer, target.layerDevice}
 
-		for _, stats := range diskstats {
-			// match with Ondat volume through diskstat row's Major and Minor numbers
-			if localVol.Major != int(stats.MajorNumber) || localVol.Minor != int(stats.MinorNumber) {
-				continue
+		// fall back to the next source when one has no stats for the device
+		var stats blockdevice.Diskstats
+		var source configondatv1.MetricsExporterDiskStatsSource
+		found := false
+		for _, source = range c.sources {
+			var err error
+			stats, found, err = reader.read(source, target.major, target.minor)
+			if err != nil {
+				logScope.Debugw("error reading device stats", "source", source, "error", err)
+			}
+			if found {
+				break
 			}
+		}
+		if !found {
+			continue
+		}
+		collected++
 
-			// Build the info metric for each diskstate line (volume) processed.
-			// Its value is not relevant as we only care about the labels.
-			// Failure to do so shouldn't stop us from collecting any further metrics.
-			metric, err := prometheus.NewConstMetric(c.info.desc, c.info.valueType, 1.0, localVol.Labels.PVC, localVol.Labels.PVCNamespace, stats.DeviceName, fmt.Sprint(localVol.Major), fmt.Sprint(localVol.Minor))
-			if err != nil {
-				logScope.Errorw("encountered error while building metric", "metric", c.info.desc.String(), "error", err)
-			} else {
+		metric, err = prometheus.NewConstMetric(c.source.desc, c.source.valueType, 1.0, append(labels, string(source))...)
+		if err != nil {
+			logScope.Errorw("encountered error while building metric", "metric", c.source.desc.String(), "error", err)
+		} else {
+			ch <- metric
+		}
+
+		// Build the info metric for each diskstate line (volume) processed.
+		// Its value is not relevant as we only care about the labels.
+		// Failure to do so shouldn't stop us from collecting any further metrics.
+		metric, err = prometheus.NewConstMetric(c.info.desc, c.info.valueType, 1.0, localVol.Labels.PVC, localVol.Labels.PVCNamespace, target.layer, target.layerDevice, stats.DeviceName, fmt.Sprint(target.major), fmt.Sprint(target.minor))
+		if err != nil {
+			logScope.Errorw("encountered error while building metric", "metric", c.info.desc.String(), "error", err)
+		} else {
+			ch <- metric
+		}
+
+		reads, writes, err := GetBlockDeviceInflight(stats.DeviceName)
+		if err != nil {
+			logScope.Debugw("error reading device requests in flight", "error", err)
+		} else {
+			for _, inflight := range []struct {
+				direction string
+				val       uint64
+			}{{"read", reads}, {"write", writes}} {
+				metric, err := prometheus.NewConstMetric(c.inflight.desc, c.inflight.valueType, float64(inflight.val), append(labels, inflight.direction)...)
+				if err != nil {
+					logScope.Errorw("encountered error while building metric", "metric", c.inflight.desc.String(), "error", err)
+					continue
+				}
 				ch <- metric
 			}
+		}
+
+		diskSectorSize := 512.0
+		logicalBlockSize, err := GetBlockDeviceLogicalBlockSize(stats.DeviceName)
+		if err != nil {
+			logScope.Errorw("error reading device logical block size, falling back to default", "error", err)
+			// continue with default sector size
+		} else {
+			diskSectorSize = float64(logicalBlockSize)
+		}
 
-			diskSectorSize := 512.0
-			logicalBlockSize, err := GetBlockDeviceLogicalBlockSize(stats.DeviceName)
+		// total diskstats record count, less the MajorNumber, MinorNumber and DeviceName
+		statCount := stats.IoStatsCount - 3
+
+		values := []float64{
+			float64(stats.ReadIOs),
+			float64(stats.ReadMerges),
+			float64(stats.ReadSectors) * diskSectorSize,
+			float64(stats.ReadTicks) * SECOND_IN_MILLISECONDS,
+			float64(stats.WriteIOs),
+			float64(stats.WriteMerges),
+			float64(stats.WriteSectors) * diskSectorSize,
+			float64(stats.WriteTicks) * SECOND_IN_MILLISECONDS,
+			float64(stats.IOsInProgress),
+			float64(stats.IOsTotalTicks) * SECOND_IN_MILLISECONDS,
+			float64(stats.WeightedIOTicks) * SECOND_IN_MILLISECONDS,
+			float64(stats.DiscardIOs),
+			float64(stats.DiscardMerges),
+			float64(stats.DiscardSectors),
+			float64(stats.DiscardTicks) * SECOND_IN_MILLISECONDS,
+			float64(stats.FlushRequestsCompleted),
+			float64(stats.TimeSpentFlushing) * SECOND_IN_MILLISECONDS,
+		}
+
+		// the generation is tracked on the device of the volume only
+		if target.layer == "" {
+			available := len(values)
+			if statCount < available {
+				available = statCount
+			}
+			if c.columns < available {
+				available = c.columns
+			}
+			num := deviceNumber{major: uint32(target.major), minor: uint32(target.minor)}
+			generation := c.generations.observe(localVol.Master.VolumeID, num, values[:available], c.isCounter, c.monotonic)
+
+			metric, err := prometheus.NewConstMetric(c.generation.desc, c.generation.valueType, float64(generation), localVol.Labels.PVC, localVol.Labels.PVCNamespace)
 			if err != nil {
-				logScope.Errorw("error reading device logical block size, falling back to default", "error", err)
-				// continue with default sector size
+				logScope.Errorw("encountered error while building metric", "metric", c.generation.desc.String(), "error", err)
 			} else {
-				diskSectorSize = float64(logicalBlockSize)
+				ch <- metric
 			}
+		}
 
-			// total diskstats record count, less the MajorNumber, MinorNumber and DeviceName
-			statCount := stats.IoStatsCount - 3
-
-			for i, val := range []float64{
-				float64(stats.ReadIOs),
-				float64(stats.ReadMerges),
-				float64(stats.ReadSectors) * diskSectorSize,
-				float64(stats.ReadTicks) * SECOND_IN_MILLISECONDS,
-				float64(stats.WriteIOs),
-				float64(stats.WriteMerges),
-				float64(stats.WriteSectors) * diskSectorSize,
-				float64(stats.WriteTicks) * SECOND_IN_MILLISECONDS,
-				float64(stats.IOsInProgress),
-				float64(stats.IOsTotalTicks) * SECOND_IN_MILLISECONDS,
-				float64(stats.WeightedIOTicks) * SECOND_IN_MILLISECONDS,
-				float64(stats.DiscardIOs),
-				float64(stats.DiscardMerges),
-				float64(stats.DiscardSectors),
-				float64(stats.DiscardTicks) * SECOND_IN_MILLISECONDS,
-				float64(stats.FlushRequestsCompleted),
-				float64(stats.TimeSpentFlushing) * SECOND_IN_MILLISECONDS,
-			} {
-				if i >= statCount {
-					// Didn't read all the above fields from diskstats.
-					// Kernel version must be lower than v5.5 where these
-					// fields don't exist yet.
-					log.Debugf("diskstats number of colums processed was %s. If on kernel older than v5.5 this msg can be ignored.")
-					break
-				}
+		for i, val := range values {
+			if i >= c.columns {
+				// the kernel doesn't have these columns
+				break
+			}
+			if i >= statCount {
+				logScope.Debugw("device stats have fewer columns than detected", "columns", stats.IoStatsCount, "expected", c.features.diskstatsColumns)
+				break
+			}
 
-				metric, err := prometheus.NewConstMetric(c.metrics[i].desc, c.metrics[i].valueType, val, localVol.Labels.PVC, localVol.Labels.PVCNamespace)
-				if err != nil {
-					logScope.Errorw("encountered error while building metric", "metric", c.metrics[i].desc.String(), "error", err)
-					continue
-				}
-				ch <- metric
+			metric, err := prometheus.NewConstMetric(c.metrics[i].desc, c.metrics[i].valueType, val, labels...)
+			if err != nil {
+				logScope.Errorw("encountered error while building metric", "metric", c.metrics[i].desc.String(), "error", err)
+				continue
 			}
+			ch <- metric
 		}
 	}
 
+	if collected == 0 && reader.err != nil {
+		log.Errorw("error reading device stats", "error", reader.err)
+		return reader.err
+	}
+
 	log.Debug("finished metrics collector")
 	return nil
 }
+
+// diskstatsTarget is a device the stats are collected of, either the device of
+// an Ondat volume or one stacked on top of it.
+type diskstatsTarget struct {
+	volume *Volume
+	major  int
+	minor  int
+	// layer and layerDevice are empty for the device of the Ondat volume
+	layer       string
+	layerDevice string
+}
+
+// deviceStatsReader reads the stats of devices from the diskstats sources,
+// /proc/diskstats being read at most once for all of them.
+type deviceStatsReader struct {
+	// devices whose /proc/diskstats rows are kept
+	devices map[deviceNumber]struct{}
+
+	procfs       map[deviceNumber]blockdevice.Diskstats
+	procfsLoaded bool
+
+	// err is the last failure to read a source, as opposed to a source
+	// having no stats for a device
+	err error
+}
+
+// read returns the stats of the given device from the given source, and
+// whether the source has any.
+func (r *deviceStatsReader) read(source configondatv1.MetricsExporterDiskStatsSource, major, minor int) (blockdevice.Diskstats, bool, error) {
+	switch source {
+	case configondatv1.MetricsExporterDiskStatsSourceProcFS:
+		if !r.procfsLoaded {
+			r.procfsLoaded = true
+			var err error
+			r.procfs, err = ProcDiskstatsByDevice(r.devices)
+			if err != nil {
+				r.err = fmt.Errorf("could not read %s: %w", DISKSTATS_PATH, err)
+			}
+		}
+		if r.procfs == nil {
+			return blockdevice.Diskstats{}, false, r.err
+		}
+		stats, ok := r.procfs[deviceNumber{major: uint32(major), minor: uint32(minor)}]
+		return stats, ok, nil
+	case configondatv1.MetricsExporterDiskStatsSourceSysFS:
+		device, err := GetBlockDeviceName(major, minor)
+		if err != nil {
+			r.err = fmt.Errorf("could not get name of device %d:%d: %w", major, minor, err)
+			return blockdevice.Diskstats{}, false, r.err
+		}
+		stats, err := GetBlockDeviceStats(device, major, minor)
+		if err != nil {
+			r.err = fmt.Errorf("could not read stats of device %s: %w", device, err)
+			return blockdevice.Diskstats{}, false, r.err
+		}
+		return stats, true, nil
+	default:
+		return blockdevice.Diskstats{}, false, fmt.Errorf("unknown diskstats source %q", source)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+func TestDiskStatsSources(t *testing.T) {
+	procfs := configondatv1.MetricsExporterDiskStatsSourceProcFS
+	sysfs := configondatv1.MetricsExporterDiskStatsSourceSysFS
+
+	tests := []struct {
+		name    string
+		host    string
+		sources []configondatv1.MetricsExporterDiskStatsSource
+
+		// expectedSource is empty when no stats are expected
+		expectedSource string
+	}{
+		{
+			name:           "default prefers procfs",
+			host:           "kernel-5.5",
+			expectedSource: "procfs",
+		},
+		{
+			name:           "sysfs only",
+			host:           "kernel-5.5",
+			sources:        []configondatv1.MetricsExporterDiskStatsSource{sysfs},
+			expectedSource: "sysfs",
+		},
+		{
+			name:           "fallback on restricted procfs",
+			host:           "procfs-restricted",
+			sources:        []configondatv1.MetricsExporterDiskStatsSource{procfs, sysfs},
+			expectedSource: "sysfs",
+		},
+		{
+			name:    "restricted procfs only",
+			host:    "procfs-restricted",
+			sources: []configondatv1.MetricsExporterDiskStatsSource{procfs},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFixtureHost(t, filepath.Join("testdata", "hosts", tt.host))
+
+			log := zap.NewNop().Sugar()
+			got, err := gatherExposition(NewCollectorGroup(log, []Collector{NewDiskStatsCollector(configondatv1.MetricsExporterConfigSpec{DiskStatsSources: tt.sources}, detectKernelFeatures(log))}))
+			require.NoError(t, err)
+			exposition := string(got)
+
+			if tt.expectedSource == "" {
+				require.NotContains(t, exposition, "ondat_disk_reads_completed_total")
+				require.Contains(t, exposition, `ondat_scrape_collector_success{collector="diskstats"} 0`)
+				return
+			}
+			require.Equal(t, 3, strings.Count(exposition, `source="`+tt.expectedSource+`"`))
+			require.Contains(t, exposition, `ondat_disk_reads_completed_total{layer="",layer_device="",pvc="pvc-a",pvc_namespace="default"} 5321`)
+			require.Contains(t, exposition, `ondat_scrape_collector_success{collector="diskstats"} 1`)
+		})
+	}
+}
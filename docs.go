@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+// METRICS_REFERENCE_PATH is where the reference of all metrics is checked in.
+const METRICS_REFERENCE_PATH = "docs/metrics.md"
+
+// referenceConfigSpec is the configuration the metrics reference is built
+// with, enabling the opt-in collectors so that their metrics are documented.
+var referenceConfigSpec = configondatv1.MetricsExporterConfigSpec{SamplingInterval: 1000, ProjectQuotas: true}
+
+// metricReference documents a metric exposed by the exporter.
+type metricReference struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Help      string   `json:"help"`
+	Labels    []string `json:"labels"`
+	Collector string   `json:"collector"`
+}
+
+// metricsReference returns the reference of every metric of the given
+// collectors, plus the metrics shared between all of them, sorted by collector
+// and name.
+func metricsReference(collectors []Collector) ([]metricReference, error) {
+	owners := map[string][]Metric{
+		"*": CollectorGroup{}.Metrics(),
+	}
+	for _, c := range collectors {
+		owners[c.Name()] = c.Metrics()
+	}
+
+	refs := []metricReference{}
+	for owner, metrics := range owners {
+		for _, m := range metrics {
+			ref, err := newMetricReference(m)
+			if err != nil {
+				return nil, err
+			}
+			ref.Collector = owner
+			refs = append(refs, ref)
+		}
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Collector != refs[j].Collector {
+			return refs[i].Collector < refs[j].Collector
+		}
+		return refs[i].Name < refs[j].Name
+	})
+	return refs, nil
+}
+
+func newMetricReference(m Metric) (metricReference, error) {
+	if m.name == "" {
+		return metricReference{}, fmt.Errorf("metric %s not built with newMetric", m.desc)
+	}
+
+	return metricReference{
+		Name:   m.name,
+		Type:   metricTypeName(m),
+		Help:   m.help,
+		Labels: append([]string{}, m.labels...),
+	}, nil
+}
+
+func metricTypeName(m Metric) string {
+	if m.histogram {
+		return "histogram"
+	}
+	switch m.valueType {
+	case prometheus.CounterValue:
+		return "counter"
+	case prometheus.GaugeValue:
+		return "gauge"
+	default:
+		return "untyped"
+	}
+}
+
+// writeMetricsReferenceMarkdown writes the given reference as a Markdown table
+// per collector.
+func writeMetricsReferenceMarkdown(w io.Writer, refs []metricReference) error {
+	var b strings.Builder
+	b.WriteString("# Metrics\n\n")
+	b.WriteString("<!-- markdownlint-disable MD013 -->\n")
+	b.WriteString("Generated by `metrics-exporter docs`, do not edit.\n")
+
+	owner := ""
+	for _, ref := range refs {
+		if ref.Collector != owner {
+			owner = ref.Collector
+			if owner == "*" {
+				b.WriteString("\n## Shared by all collectors\n\n")
+			} else {
+				fmt.Fprintf(&b, "\n## %s collector\n\n", owner)
+			}
+			b.WriteString("| Name | Type | Help | Labels |\n")
+			b.WriteString("| ---- | ---- | ---- | ------ |\n")
+		}
+
+		labels := make([]string, 0, len(ref.Labels))
+		for _, l := range ref.Labels {
+			labels = append(labels, "`"+l+"`")
+		}
+		help := strings.ReplaceAll(ref.Help, "|", "\\|")
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", ref.Name, ref.Type, help, strings.Join(labels, ", "))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// runDocs implements the docs subcommand, printing the reference of all the
+// metrics the exporter may expose.
+func runDocs(args []string) int {
+	flags := flag.NewFlagSet("docs", flag.ExitOnError)
+	format := flags.String("format", "markdown", "Output format, markdown or json.")
+	_ = flags.Parse(args)
+
+	refs, err := metricsReference(newMetricsCollectors(zap.NewNop().Sugar(), referenceConfigSpec, allKernelFeatures))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build metrics reference: %s\n", err)
+		return 1
+	}
+
+	switch *format {
+	case "markdown":
+		err = writeMetricsReferenceMarkdown(os.Stdout, refs)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(refs)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write metrics reference: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// TestMetricsReference fails when the checked-in metrics reference drifts
+// from the metrics the collectors define.
+func TestMetricsReference(t *testing.T) {
+	refs, err := metricsReference(newMetricsCollectors(zap.NewNop().Sugar(), referenceConfigSpec, allKernelFeatures))
+	require.NoError(t, err)
+
+	for _, ref := range refs {
+		require.NotEmpty(t, ref.Name)
+		require.NotEmpty(t, ref.Help, ref.Name)
+		require.NotEmpty(t, ref.Labels, ref.Name)
+	}
+
+	var got bytes.Buffer
+	require.NoError(t, writeMetricsReferenceMarkdown(&got, refs))
+
+	if *update {
+		require.NoError(t, ioutil.WriteFile(METRICS_REFERENCE_PATH, got.Bytes(), 0644))
+	}
+
+	want, err := ioutil.ReadFile(METRICS_REFERENCE_PATH)
+	require.NoError(t, err)
+	require.Equal(t, string(want), got.String(), "%s is outdated, run \"go test -run TestMetricsReference -update .\" to regenerate it", METRICS_REFERENCE_PATH)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+const EXT4_COLLECTOR_NAME = string(configondatv1.MetricsExporterCollectorExt4)
+
+// ext4Attributes are the sysfs attributes, relative to /sys/fs/ext4/<dev>,
+// read for each ext4 filesystem along with the factor turning them into base
+// units. Order MUST match the metrics of the Ext4Collector.
+var ext4Attributes = []struct {
+	file  string
+	scale float64
+}{
+	{"errors_count", 1},
+	{"first_error_time", 1},
+	{"last_error_time", 1},
+	{"lifetime_write_kbytes", KIBIBYTE},
+	{"msg_count", 1},
+}
+
+// Ext4Collector gathers the error and lifetime statistics the kernel keeps for
+// the ext4 filesystems of the Ondat volumes, along with the statistics of their
+// jbd2 journal.
+type Ext4Collector struct {
+	// content order MUST match ext4Attributes
+	metrics []Metric
+
+	journalTransactions          Metric
+	journalRequestedTransactions Metric
+	journalMaxTransactionBlocks  Metric
+	journalCommitTime            Metric
+	journalPhaseTime             Metric
+	journalHandles               Metric
+	journalBlocks                Metric
+	journalLoggedBlocks          Metric
+}
+
+func NewExt4Collector() Ext4Collector {
+	return Ext4Collector{
+		metrics: []Metric{
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "errors_total"),
+				"Number of errors the filesystem encountered, as recorded in its superblock.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "first_error_timestamp_seconds"),
+				"Time of the first error of the filesystem since the epoch, 0 when it never encountered one.",
+				fsLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "last_error_timestamp_seconds"),
+				"Time of the last error of the filesystem since the epoch, 0 when it never encountered one.",
+				fsLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "lifetime_written_bytes_total"),
+				"Number of bytes written to the filesystem since it was created.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "messages_total"),
+				"Number of kernel messages about the filesystem since it was mounted.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+		},
+		journalTransactions: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "journal_transactions_total"),
+			"Number of transactions committed to the journal since the filesystem was mounted.",
+			fsLabels,
+			prometheus.CounterValue,
+		),
+		journalRequestedTransactions: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "journal_requested_transactions_total"),
+			"Number of journal commits requested before the commit interval, e.g. by fsync().",
+			fsLabels,
+			prometheus.CounterValue,
+		),
+		journalMaxTransactionBlocks: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "journal_max_transaction_blocks"),
+			"The maximum number of blocks of a journal transaction.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		journalCommitTime: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "journal_commit_time_seconds"),
+			"Average time to commit a transaction to the journal.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		journalPhaseTime: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "journal_phase_time_seconds"),
+			"Average time journal transactions spend in each phase of their lifetime.",
+			append(fsLabels, "phase"),
+			prometheus.GaugeValue,
+		),
+		journalHandles: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "journal_handles_per_transaction"),
+			"Average number of operations per journal transaction.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		journalBlocks: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "journal_blocks_per_transaction"),
+			"Average number of blocks modified per journal transaction.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		journalLoggedBlocks: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, EXT4_SUBSYSTEM, "journal_logged_blocks_per_transaction"),
+			"Average number of blocks written to the journal per transaction, descriptor blocks included.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+	}
+}
+
+func (c Ext4Collector) Name() string {
+	return EXT4_COLLECTOR_NAME
+}
+
+func (c Ext4Collector) Metrics() []Metric {
+	return append(c.metrics,
+		c.journalTransactions,
+		c.journalRequestedTransactions,
+		c.journalMaxTransactionBlocks,
+		c.journalCommitTime,
+		c.journalPhaseTime,
+		c.journalHandles,
+		c.journalBlocks,
+		c.journalLoggedBlocks,
+	)
+}
+
+func (c Ext4Collector) Collect(log *zap.SugaredLogger, ch chan<- prometheus.Metric, ondatVolumes []*Volume) error {
+	log.Debug("starting ext4 metrics collector")
+	log = log.With("collector", EXT4_COLLECTOR_NAME)
+
+	if len(ondatVolumes) == 0 {
+		log.Debug("no Ondat volumes, metrics collector finished early")
+		return nil
+	}
+
+	mounts, err := listOndatMounts(log, ondatVolumes)
+	if err != nil {
+		log.Errorw("failed to read mounts", "error", err)
+		return err
+	}
+
+	for _, mount := range firstMounts(mounts) {
+		labels := mount.labels
+		if labels.fsType != "ext4" {
+			continue
+		}
+		logScope := log.With("pvc", mount.pvc, "pvc_namespace", mount.pvcNamespace, "device", labels.device, "mountpoint", labels.mountPoint)
+
+		if mount.dev.major == 0 && mount.dev.minor == 0 {
+			logScope.Debug("unknown device number, skipping filesystem")
+			continue
+		}
+		// ext4 names its sysfs node after the kernel name of its device
+		device, err := GetBlockDeviceName(int(mount.dev.major), int(mount.dev.minor))
+		if err != nil {
+			logScope.Errorw("error getting device name", "major", mount.dev.major, "minor", mount.dev.minor, "error", err)
+			continue
+		}
+
+		// best effort, older kernels lack some of the attributes
+		for i, attr := range ext4Attributes {
+			val, err := readHostUint("/sys/fs/ext4/" + device + "/" + attr.file)
+			if err != nil {
+				logScope.Debugw("error reading ext4 attribute", "attribute", attr.file, "error", err)
+				continue
+			}
+
+			metric, err := prometheus.NewConstMetric(c.metrics[i].desc, c.metrics[i].valueType, float64(val)*attr.scale, mount.pvc, mount.pvcNamespace, labels.device, labels.fsType, labels.mountPoint)
+			if err != nil {
+				logScope.Errorw("encountered error while building metric", "metric", c.metrics[i].desc.String(), "error", err)
+				continue
+			}
+			ch <- metric
+		}
+
+		c.collectJournal(logScope, ch, mount, device)
+	}
+
+	log.Debug("finished metrics collector")
+	return nil
+}
+
+// collectJournal reports the statistics of the internal jbd2 journal of the
+// given ext4 filesystem, named after the device and the journal inode 8.
+func (c Ext4Collector) collectJournal(log *zap.SugaredLogger, ch chan<- prometheus.Metric, mount ondatMount, device string) {
+	data, err := readHostFile("/proc/fs/jbd2/" + device + "-8/info")
+	if err != nil {
+		// filesystems without a journal or with an external one
+		log.Debugw("error reading jbd2 info", "error", err)
+		return
+	}
+	info, err := parseJBD2Info(bytes.NewReader(data))
+	if err != nil {
+		log.Errorw("error parsing jbd2 info", "error", err)
+		return
+	}
+
+	labels := mount.labels
+	emit := func(m Metric, val float64, extraLabels ...string) {
+		metric, err := prometheus.NewConstMetric(m.desc, m.valueType, val, append([]string{mount.pvc, mount.pvcNamespace, labels.device, labels.fsType, labels.mountPoint}, extraLabels...)...)
+		if err != nil {
+			log.Errorw("encountered error while building metric", "metric", m.desc.String(), "error", err)
+			return
+		}
+		ch <- metric
+	}
+
+	emit(c.journalTransactions, float64(info.transactions))
+	emit(c.journalRequestedTransactions, float64(info.requestedTransactions))
+	emit(c.journalMaxTransactionBlocks, float64(info.maxTransactionBlocks))
+	if !info.hasAverages {
+		return
+	}
+	emit(c.journalCommitTime, info.commitTime.Seconds())
+	for phase, d := range info.phases {
+		emit(c.journalPhaseTime, d.Seconds(), phase)
+	}
+	emit(c.journalHandles, float64(info.handlesPerTransaction))
+	emit(c.journalBlocks, float64(info.blocksPerTransaction))
+	emit(c.journalLoggedBlocks, float64(info.loggedBlocksPerTransaction))
+}
@@ -2,12 +2,13 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -17,80 +18,171 @@ import (
 	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
 )
 
-const FILE_SYSTEM_COLLECTOR_NAME = string(configondatv1.MetricsExporterCollectorFileSystem)
-
-var stuckMounts = make(map[string]struct{})
-var stuckMountsMtx = &sync.Mutex{}
+const (
+	FILE_SYSTEM_COLLECTOR_NAME = string(configondatv1.MetricsExporterCollectorFileSystem)
+
+	// a filesystem is pending a resize when it misses more of its device than
+	// its metadata can take: the ext4 inode tables, of EXT4_INODE_SIZE bytes
+	// per inode, then RESIZE_METADATA_RATIO of the device up to
+	// RESIZE_METADATA_MAX for the journal or log, the bitmaps and the
+	// descriptors kept to grow
+	EXT4_INODE_SIZE       = 256
+	RESIZE_METADATA_RATIO = 1.0 / 32
+	RESIZE_METADATA_MAX   = 2 * 1024 * 1024 * 1024
+)
 
 type filesystemLabels struct {
 	device, mountPoint, fsType, options string
+
+	// the following are only read from mountinfo, mountID is 0 otherwise
+	mountID, parentID int
+	// dev is the device number of the filesystem
+	dev  deviceNumber
+	root string
+	// optionalFields are the propagation fields, e.g. "shared:1"
+	optionalFields []string
+	superOptions   string
+}
+
+// ondatMount is a mount of the filesystem of an Ondat volume.
+type ondatMount struct {
+	labels            filesystemLabels
+	pvc, pvcNamespace string
+	// dev is the device the filesystem is mounted from, the Ondat one or one
+	// stacked on top of it, 0:0 when unknown
+	dev deviceNumber
 }
 
 type FileSystemCollector struct {
-	deviceErrors Metric
+	deviceErrors    Metric
+	mountStuck      Metric
+	mountStuckSince Metric
+	mountRecoveries Metric
+
+	statfsInflight Metric
+	mountInfo      Metric
+
+	readOnlyTransitions    Metric
+	readOnlyLastTransition Metric
+
+	resizePending Metric
+	resizeGap     Metric
 
 	metrics []Metric
+
+	stuckMounts *stuckMountTracker
+	readOnly    *readOnlyTracker
 }
 
-func NewFileSystemCollector() FileSystemCollector {
+// NewFileSystemCollector returns a filesystem collector marking mounts as
+// stuck when statfs() takes longer than the configured timeout, and watching
+// the mount table for read-only transitions when a poll interval is set.
+func NewFileSystemCollector(cfg configondatv1.MetricsExporterConfigSpec) FileSystemCollector {
+	stuckMountTimeout := cfg.StuckMountTimeout
+	if stuckMountTimeout == 0 {
+		stuckMountTimeout = (&configondatv1.MetricsExporterConfig{}).Default().StuckMountTimeout
+	}
+
 	return FileSystemCollector{
-		deviceErrors: Metric{
-			desc: prometheus.NewDesc(
-				prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "device_error"),
-				"Whether an error occurred while getting statistics for the given device.",
-				fsLabels, nil,
-			),
-			valueType: prometheus.GaugeValue,
-		},
+		stuckMounts: newStuckMountTracker(time.Duration(stuckMountTimeout) * time.Second),
+		readOnly:    newReadOnlyTracker(time.Duration(cfg.MountPollInterval) * time.Millisecond),
+		readOnlyTransitions: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "readonly_transitions_total"),
+			"The number of times the mount switched between read-write and read-only since the exporter first saw it.",
+			fsLabels,
+			prometheus.CounterValue,
+		),
+		readOnlyLastTransition: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "readonly_last_transition_timestamp_seconds"),
+			"When the mount last switched between read-write and read-only, 0 when it never did.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		mountStuck: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "mount_stuck"),
+			"Whether statfs() on the mount point did not return in time, the mount is then no longer probed by scrapes.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		mountStuckSince: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "mount_stuck_since_timestamp_seconds"),
+			"When the mount point got stuck, only set for stuck mounts.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		statfsInflight: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "statfs_inflight"),
+			"Whether a statfs() system call is still running on the mount point after the scrape gave up waiting for it.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		mountInfo: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "mount_info"),
+			"Every mount of the filesystems of the Ondat volumes, the other filesystem metrics are only reported for the first mount of each filesystem.",
+			mountInfoLabels,
+			prometheus.GaugeValue,
+		),
+		mountRecoveries: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "mount_recoveries_total"),
+			"The number of times the mount point answered statfs() again after being stuck.",
+			fsLabels,
+			prometheus.CounterValue,
+		),
+		resizePending: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "resize_pending"),
+			"Whether the filesystem is noticeably smaller than its device, e.g. after a volume expansion the filesystem was not grown for.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		resizeGap: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "resize_gap_bytes"),
+			"The size of the device minus the size of the filesystem in bytes, the filesystem metadata included.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
+		deviceErrors: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "device_error"),
+			"Whether an error occurred while getting statistics for the given device.",
+			fsLabels,
+			prometheus.GaugeValue,
+		),
 		metrics: []Metric{
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "size_bytes"),
-					"Filesystem size in bytes.",
-					fsLabels, nil,
-				),
-				valueType: prometheus.GaugeValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "free_bytes"),
-					"Filesystem free space in bytes.",
-					fsLabels, nil,
-				),
-				valueType: prometheus.GaugeValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "avail_bytes"),
-					"Filesystem space available to non-root users in bytes.",
-					fsLabels, nil,
-				),
-				valueType: prometheus.GaugeValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "files"),
-					"Filesystem total file nodes.",
-					fsLabels, nil,
-				),
-				valueType: prometheus.GaugeValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "files_free"),
-					"Filesystem total free file nodes.",
-					fsLabels, nil,
-				),
-				valueType: prometheus.GaugeValue,
-			},
-			{
-				desc: prometheus.NewDesc(
-					prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "readonly"),
-					"Filesystem read-only status.",
-					fsLabels, nil,
-				),
-				valueType: prometheus.GaugeValue,
-			},
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "size_bytes"),
+				"Filesystem size in bytes.",
+				fsLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "free_bytes"),
+				"Filesystem free space in bytes.",
+				fsLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "avail_bytes"),
+				"Filesystem space available to non-root users in bytes.",
+				fsLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "files"),
+				"Filesystem total file nodes.",
+				fsLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "files_free"),
+				"Filesystem total free file nodes.",
+				fsLabels,
+				prometheus.GaugeValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "readonly"),
+				"Filesystem read-only status.",
+				fsLabels,
+				prometheus.GaugeValue,
+			),
 		},
 	}
 }
@@ -99,6 +191,14 @@ func (c FileSystemCollector) Name() string {
 	return FILE_SYSTEM_COLLECTOR_NAME
 }
 
+func (c FileSystemCollector) close() {
+	c.readOnly.close()
+}
+
+func (c FileSystemCollector) Metrics() []Metric {
+	return append([]Metric{c.deviceErrors, c.mountStuck, c.mountStuckSince, c.mountRecoveries, c.statfsInflight, c.mountInfo, c.readOnlyTransitions, c.readOnlyLastTransition, c.resizePending, c.resizeGap}, c.metrics...)
+}
+
 func (c FileSystemCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheus.Metric, ondatVolumes []*Volume) error {
 	log.Debug("starting filesystem metrics collector")
 	log = log.With("collector", FILE_SYSTEM_COLLECTOR_NAME)
@@ -110,63 +210,89 @@ func (c FileSystemCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheu
 
 	// TODO consider skipping getting all fs mounted devices
 	// and fetch the data for each Ondat volume directly
-	mps, err := mountPointDetails(log)
+	mounts, err := listOndatMounts(log, ondatVolumes)
 	if err != nil {
 		log.Errorw("failed to read mounts", "error", err)
 		return err
 	}
 
-	for _, labels := range mps {
-		if !strings.HasPrefix(labels.device, "/var/lib/storageos/volumes") {
-			continue
+	for _, mount := range mounts {
+		labels := mount.labels
+		var mountID, parentID string
+		if labels.mountID != 0 {
+			mountID, parentID = strconv.Itoa(labels.mountID), strconv.Itoa(labels.parentID)
 		}
-
-		// extract the volume ID from the mount
-		// format: /var/lib/storageos/volumes/v.06115715-2901-49d4-9a05-fd4641b82d6d
-		tmp := strings.Split(labels.device, "/")
-		volID := strings.TrimPrefix(tmp[len(tmp)-1], "v.")
-
-		var pvc, pvcNamespace string
-		for _, vol := range ondatVolumes {
-			if vol.Master.VolumeID == volID {
-				pvc = vol.Labels.PVC
-				pvcNamespace = vol.Labels.PVCNamespace
-				break
-			}
+		metric, err := prometheus.NewConstMetric(c.mountInfo.desc, c.mountInfo.valueType, 1, mount.pvc, mount.pvcNamespace, labels.device, labels.fsType, labels.mountPoint, mountID, parentID, labels.root, labels.propagation())
+		if err != nil {
+			log.Errorw("encountered error while building metric", "metric", c.mountInfo.desc.String(), "error", err)
+			continue
 		}
+		ch <- metric
+	}
 
+	// a filesystem is mounted several times, e.g. the kubelet's global mount
+	// then a bind mount per pod: all mounts are listed, the filesystem stats
+	// are read once from the first of them
+	filesystems := firstMounts(mounts)
+
+	// set when a mount couldn't be probed, e.g. all the statfs() workers are
+	// blocked by hung mounts
+	var probeErr error
+	mountPoints := map[string]struct{}{}
+	for _, mount := range filesystems {
+		labels, pvc, pvcNamespace := mount.labels, mount.pvc, mount.pvcNamespace
 		logScope := log.With("pvc", pvc, "pvc_namespace", pvcNamespace, "device", labels.device, "mountpoint", labels.mountPoint)
 
-		stuckMountsMtx.Lock()
-		if _, ok := stuckMounts[labels.mountPoint]; ok {
-			logScope.Errorw("mount point is in an unresponsible state", "mountpoint", labels.mountPoint)
-			metric, err := prometheus.NewConstMetric(c.deviceErrors.desc, c.deviceErrors.valueType, 1, pvc, pvcNamespace, labels.device, labels.fsType, labels.mountPoint)
+		emit := func(m Metric, val float64) {
+			metric, err := prometheus.NewConstMetric(m.desc, m.valueType, val, pvc, pvcNamespace, labels.device, labels.fsType, labels.mountPoint)
 			if err != nil {
-				logScope.Errorw("encountered error while building metric", "metric", c.deviceErrors.desc.String(), "error", err)
-				continue
+				logScope.Errorw("encountered error while building metric", "metric", m.desc.String(), "error", err)
+				return
 			}
 			ch <- metric
-
-			stuckMountsMtx.Unlock()
-			continue
 		}
-		stuckMountsMtx.Unlock()
+		mountPoints[labels.mountPoint] = struct{}{}
 
-		// The success channel is used do tell the "watcher" that the stat
-		// finished successfully. The channel is closed on success.
-		success := make(chan struct{})
-		go stuckMountWatcher(log, labels.mountPoint, success, log)
+		// the mount table is readable whatever the state of the mount
+		roState := c.readOnly.observe(labels.mountPoint, labels.readOnly())
+		var lastTransition float64
+		if roState.transitions > 0 {
+			lastTransition = float64(roState.lastTransition.UnixNano()) / 1e9
+		}
+		emit(c.readOnlyTransitions, float64(roState.transitions))
+		emit(c.readOnlyLastTransition, lastTransition)
 
+		// stuck mounts are only probed in the background
 		buf := new(unix.Statfs_t)
-		err = unix.Statfs(labels.mountPoint, buf)
-		stuckMountsMtx.Lock()
-		close(success)
-		// If the mount has been marked as stuck, unmark it and log it's recovery.
-		if _, ok := stuckMounts[labels.mountPoint]; ok {
-			logScope.Debugw("mount point has recovered, monitoring will resume", "mountpoint", labels.mountPoint)
-			delete(stuckMounts, labels.mountPoint)
+		since, stuck := c.stuckMounts.stuckSince(labels.mountPoint)
+		if !stuck {
+			err = c.stuckMounts.statfs(logScope, labels.mountPoint, buf)
+			if errors.Is(err, errStatfsTimeout) {
+				since, stuck = c.stuckMounts.stuckSince(labels.mountPoint)
+			}
+			if errors.Is(err, errStatfsQueued) || errors.Is(err, errStatfsQueueFull) {
+				// no worker ran statfs(), the mount may be fine
+				logScope.Errorw("could not probe mount point", "mountpoint", labels.mountPoint, "error", err)
+				probeErr = err
+				continue
+			}
 		}
-		stuckMountsMtx.Unlock()
+		var inflight float64
+		if c.stuckMounts.pool.inflight(labels.mountPoint) {
+			inflight = 1
+		}
+		emit(c.statfsInflight, inflight)
+
+		if stuck {
+			logScope.Errorw("mount point is in an unresponsive state", "mountpoint", labels.mountPoint, "since", since)
+			emit(c.deviceErrors, 1)
+			emit(c.mountStuck, 1)
+			emit(c.mountStuckSince, float64(since.UnixNano())/1e9)
+			emit(c.mountRecoveries, float64(c.stuckMounts.recoveryCount(labels.mountPoint)))
+			continue
+		}
+		emit(c.mountStuck, 0)
+		emit(c.mountRecoveries, float64(c.stuckMounts.recoveryCount(labels.mountPoint)))
 
 		if err != nil {
 			logScope.Errorw("error on statfs() system call", "device", labels.device, "mountpoint", labels.mountPoint, "error", err)
@@ -180,11 +306,8 @@ func (c FileSystemCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheu
 		}
 
 		var ro float64
-		for _, option := range strings.Split(labels.options, ",") {
-			if option == "ro" {
-				ro = 1
-				break
-			}
+		if labels.readOnly() {
+			ro = 1
 		}
 
 		for i, val := range []float64{
@@ -202,6 +325,23 @@ func (c FileSystemCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheu
 			}
 			ch <- metric
 		}
+
+		size := float64(buf.Blocks) * float64(buf.Bsize)
+		if deviceSize, err := mountDeviceSize(mount); err != nil {
+			logScope.Debugw("error getting device size", "error", err)
+		} else {
+			gap := deviceSize - size
+			if gap < 0 {
+				gap = 0
+			}
+			var pending float64
+			if gap > metadataOverhead(labels.fsType, deviceSize, buf.Files) {
+				pending = 1
+			}
+			emit(c.resizePending, pending)
+			emit(c.resizeGap, gap)
+		}
+
 		metric, err := prometheus.NewConstMetric(c.deviceErrors.desc, c.deviceErrors.valueType, 0, pvc, pvcNamespace, labels.device, labels.fsType, labels.mountPoint)
 		if err != nil {
 			logScope.Errorw("encountered error while building metric", "metric", c.deviceErrors.desc.String(), "error", err)
@@ -209,47 +349,149 @@ func (c FileSystemCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheu
 		}
 		ch <- metric
 	}
+	c.stuckMounts.forgetRecoveries(mountPoints)
+	c.readOnly.forget(mountPoints)
+	if c.readOnly.interval > 0 {
+		c.readOnly.start(log)
+	}
 
 	log.Debug("finished metrics collector")
-	return nil
+	return probeErr
+}
+
+// mountedVolumeID returns the ID of the Ondat volume behind the given mounted
+// device, if it is one.
+func mountedVolumeID(device string) (string, bool) {
+	if !strings.HasPrefix(device, STOS_VOLUMES_PATH) {
+		return "", false
+	}
+
+	// extract the volume ID from the mount
+	// format: /var/lib/storageos/volumes/v.06115715-2901-49d4-9a05-fd4641b82d6d
+	tmp := strings.Split(device, "/")
+	return strings.TrimPrefix(tmp[len(tmp)-1], "v."), true
+}
+
+// listOndatMounts returns the mounts of the filesystems of the given Ondat
+// volumes, mounted from their device or from a device stacked on top of it.
+func listOndatMounts(log *zap.SugaredLogger, ondatVolumes []*Volume) ([]ondatMount, error) {
+	mps, err := mountPointDetails(log)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make(map[string]*Volume, len(ondatVolumes))
+	for _, vol := range ondatVolumes {
+		volumes[vol.Master.VolumeID] = vol
+	}
+
+	// filesystems may be mounted from devices stacked on top of the Ondat
+	// ones, e.g. dm-crypt or LVM
+	stacked := discoverStackedDevices(log, ondatVolumes)
+
+	var mounts []ondatMount
+	for _, labels := range mps {
+		mount := ondatMount{labels: labels, dev: labels.dev}
+
+		volID, ok := mountedVolumeID(labels.device)
+		if ok {
+			if vol, ok := volumes[volID]; ok && labels.mountID == 0 {
+				mount.dev = deviceNumber{major: uint32(vol.Major), minor: uint32(vol.Minor)}
+			}
+		} else {
+			dev, isStacked := stacked.byMountSource(labels.device)
+			if !isStacked {
+				continue
+			}
+			volID = dev.volume.Master.VolumeID
+			if labels.mountID == 0 {
+				mount.dev = deviceNumber{major: uint32(dev.major), minor: uint32(dev.minor)}
+			}
+		}
+
+		if vol, ok := volumes[volID]; ok {
+			mount.pvc = vol.Labels.PVC
+			mount.pvcNamespace = vol.Labels.PVCNamespace
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, nil
 }
 
-// stuckMountWatcher listens on the given success channel and if the channel closes
-// then the watcher does nothing. If instead the timeout is reached, the
-// mount point that is being watched is marked as stuck.
-func stuckMountWatcher(log *zap.SugaredLogger, mountPoint string, success chan struct{}, logger *zap.SugaredLogger) {
-	mountCheckTimer := time.NewTimer(time.Second * 5)
-	defer mountCheckTimer.Stop()
-	select {
-	case <-success:
-		// Success
-	case <-mountCheckTimer.C:
-		// Timed out, mark mount as stuck
-		stuckMountsMtx.Lock()
-		select {
-		case <-success:
-			// Success came in just after the timeout was reached, don't label the mount as stuck
-		default:
-			log.Errorw("mount point timed out, it is being labeled as stuck and will not be monitored", "mountpoint", mountPoint)
-			stuckMounts[mountPoint] = struct{}{}
+// mountDeviceSize returns the size in bytes of the device the filesystem of
+// the given mount is mounted from.
+func mountDeviceSize(mount ondatMount) (float64, error) {
+	if mount.dev.major == 0 && mount.dev.minor == 0 {
+		return 0, errors.New("unknown device number")
+	}
+	device, err := GetBlockDeviceName(int(mount.dev.major), int(mount.dev.minor))
+	if err != nil {
+		return 0, err
+	}
+	// in 512 bytes sectors whatever the logical block size of the device
+	sectors, err := readHostUint("/sys/block/" + device + "/size")
+	if err != nil {
+		return 0, err
+	}
+	return float64(sectors) * SYSFS_SECTOR_SIZE, nil
+}
+
+// metadataOverhead returns the most space the metadata of a filesystem of the
+// given type can take on a device of the given size, outside of the size
+// statfs() reports.
+func metadataOverhead(fsType string, deviceSize float64, files uint64) float64 {
+	overhead := deviceSize * RESIZE_METADATA_RATIO
+	if overhead > RESIZE_METADATA_MAX {
+		overhead = RESIZE_METADATA_MAX
+	}
+	// ext4 inode tables are allocated when formatting, unlike XFS ones
+	if fsType == "ext4" || fsType == "ext3" || fsType == "ext2" {
+		overhead += float64(files) * EXT4_INODE_SIZE
+	}
+	return overhead
+}
+
+// firstMounts returns the first mount of each filesystem of the given mounts,
+// the one with the lowest mount ID or the first one listed when unknown.
+func firstMounts(mounts []ondatMount) []ondatMount {
+	var first []ondatMount
+	bySuperblock := map[string]int{}
+	for _, mount := range mounts {
+		if i, ok := bySuperblock[mount.labels.superblock()]; ok {
+			if mount.labels.mountID < first[i].labels.mountID {
+				first[i] = mount
+			}
+			continue
 		}
-		stuckMountsMtx.Unlock()
+		bySuperblock[mount.labels.superblock()] = len(first)
+		first = append(first, mount)
 	}
+	return first
 }
 
+// mountPointDetails returns the mounts of the host's mount namespace, read from
+// its mountinfo when possible or its mounts otherwise.
 func mountPointDetails(logger *zap.SugaredLogger) ([]filesystemLabels, error) {
-	file, err := os.Open("/proc/1/mounts")
+	content, err := readHostFile("/proc/1/mountinfo")
+	if errors.Is(err, os.ErrNotExist) {
+		// `/proc/1` is missing due to hidepid
+		content, err = readHostFile("/proc/self/mountinfo")
+	}
+	if err == nil {
+		return parseMountInfo(bytes.NewReader(content))
+	}
+	logger.Debugw("error reading mountinfo, falling back to mounts", "error", err)
+
+	content, err = readHostFile("/proc/1/mounts")
 	if errors.Is(err, os.ErrNotExist) {
 		// Fallback to `/proc/mounts` if `/proc/1/mounts` is missing due hidepid.
-		// level.Debug(logger).Log("msg", "Reading root mounts failed, falling back to system mounts", "err", err)
-		file, err = os.Open("/proc/mounts")
+		content, err = readHostFile("/proc/mounts")
 	}
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	return parseFilesystemLabels(file)
+	return parseFilesystemLabels(bytes.NewReader(content))
 }
 
 func parseFilesystemLabels(r io.Reader) ([]filesystemLabels, error) {
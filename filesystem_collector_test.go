@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+func FuzzParseFilesystemLabels(f *testing.F) {
+	for _, seed := range fuzzSeeds(f, "root/proc/*mounts") {
+		f.Add(seed)
+	}
+	for _, seed := range fuzzSeeds(f, "root/proc/1/mounts") {
+		f.Add(seed)
+	}
+	f.Add("/dev/sda1 /mnt\\040with\\011blanks ext4 rw 0 0")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		labels, err := parseFilesystemLabels(strings.NewReader(content))
+		if err != nil {
+			return
+		}
+		for _, l := range labels {
+			require.NotEmpty(t, l.device)
+			require.NotEmpty(t, l.fsType)
+			require.NotEmpty(t, l.options)
+		}
+	})
+}
+
+func TestFileSystemCollectorStuckMount(t *testing.T) {
+	useFixtureHost(t, filepath.Join("testdata", "hosts", "kernel-5.5"))
+
+	// statfs() on the mount of pvc-b hangs until unblocked
+	unblock := make(chan struct{})
+	hostStatfs := statfs
+	statfs = func(path string, buf *unix.Statfs_t) error {
+		if strings.Contains(path, "pvc-78e88095") {
+			<-unblock
+		}
+		return hostStatfs(path, buf)
+	}
+
+	log := zap.NewNop().Sugar()
+	c := NewFileSystemCollector(configondatv1.MetricsExporterConfigSpec{})
+	c.stuckMounts.timeout = 10 * time.Millisecond
+	group := NewCollectorGroup(log, []Collector{c})
+
+	// the scrape doesn't wait for the hung mount
+	got, err := gatherExposition(group)
+	require.NoError(t, err)
+	exposition := string(got)
+	require.Regexp(t, `ondat_filesystem_mount_stuck\{[^}]*pvc="pvc-b"[^}]*\} 1`, exposition)
+	require.Regexp(t, `ondat_filesystem_mount_stuck_since_timestamp_seconds\{[^}]*pvc="pvc-b"[^}]*\} `, exposition)
+	require.Regexp(t, `ondat_filesystem_device_error\{[^}]*pvc="pvc-b"[^}]*\} 1`, exposition)
+	require.Regexp(t, `ondat_filesystem_mount_stuck\{[^}]*pvc="pvc-a"[^}]*\} 0`, exposition)
+	require.NotRegexp(t, `ondat_filesystem_mount_stuck_since_timestamp_seconds\{[^}]*pvc="pvc-a"`, exposition)
+	require.Regexp(t, `ondat_filesystem_statfs_inflight\{[^}]*pvc="pvc-b"[^}]*\} 1`, exposition)
+	require.Regexp(t, `ondat_filesystem_statfs_inflight\{[^}]*pvc="pvc-a"[^}]*\} 0`, exposition)
+
+	close(unblock)
+	require.Eventually(t, func() bool {
+		_, stuck := c.stuckMounts.stuckSince("/var/lib/kubelet/pods/7a9c2d10-5b4e-4a8f-8d3c-6e2f1a0b9c8d/volumes/kubernetes.io~csi/pvc-78e88095-e690-49be-b0f3-3f735ef084a5/mount")
+		return !stuck
+	}, time.Second, time.Millisecond)
+	got, err = gatherExposition(group)
+	require.NoError(t, err)
+	exposition = string(got)
+	require.Regexp(t, `ondat_filesystem_mount_stuck\{[^}]*pvc="pvc-b"[^}]*\} 0`, exposition)
+	require.Regexp(t, `ondat_filesystem_mount_recoveries_total\{[^}]*pvc="pvc-b"[^}]*\} 1`, exposition)
+	require.Regexp(t, `ondat_filesystem_device_error\{[^}]*pvc="pvc-b"[^}]*\} 0`, exposition)
+	require.Regexp(t, `ondat_filesystem_statfs_inflight\{[^}]*pvc="pvc-b"[^}]*\} 0`, exposition)
+}
+
+func TestMetadataOverhead(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	tests := []struct {
+		name       string
+		fsType     string
+		deviceSize float64
+		fsSize     float64
+		files      uint64
+		pending    bool
+	}{
+		{name: "ext4 in sync", fsType: "ext4", deviceSize: 10 * gib, fsSize: 10*gib - 237744128, files: 655360},
+		{name: "ext4 expanded by 10%", fsType: "ext4", deviceSize: 11 * gib, fsSize: 10*gib - 237744128, files: 655360, pending: true},
+		{name: "large ext4 in sync", fsType: "ext4", deviceSize: 1024 * gib, fsSize: 1024*gib - 16*gib - 1*gib - 128*1024*1024, files: 67108864},
+		{name: "xfs in sync", fsType: "xfs", deviceSize: 5 * gib, fsSize: 5*gib - 10*1024*1024, files: 2621440},
+		{name: "xfs expanded by 10%", fsType: "xfs", deviceSize: 5.5 * gib, fsSize: 5*gib - 10*1024*1024, files: 2621440, pending: true},
+	}
+	for _, tt := range tests {
+		gap := tt.deviceSize - tt.fsSize
+		require.Equal(t, tt.pending, gap > metadataOverhead(tt.fsType, tt.deviceSize, tt.files), tt.name)
+	}
+}
@@ -25,41 +25,61 @@ const (
 	//
 	// "ondat_scrape_..."
 	SCRAPE_SUBSYSTEM = "scrape"
+	// EXPORTER_SUBSYSTEM defines the category about the exporter and the host
+	// it runs on
+	//
+	// "ondat_exporter_..."
+	EXPORTER_SUBSYSTEM = "exporter"
+	// EXT4_SUBSYSTEM defines the category of the metrics specific to ext4
+	// filesystems
+	//
+	// "ondat_ext4_..."
+	EXT4_SUBSYSTEM = "ext4"
+	// XFS_SUBSYSTEM defines the category of the metrics specific to XFS
+	// filesystems
+	//
+	// "ondat_xfs_..."
+	XFS_SUBSYSTEM = "xfs"
 )
 
 var (
 	// labels present in all disk metrics to identify the PVC
 	pvcLabels = []string{"pvc", "pvc_namespace"}
 
+	// labels present in all diskstats metrics, the layer ones identify the
+	// devices stacked on top of the PVC device and are empty for the PVC
+	// device itself
+	diskstatsLabels = append(pvcLabels, "layer", "layer_device")
+
 	// labels present in all filesystem metrics to identify the device
 	fsLabels = []string{"pvc", "pvc_namespace", "device", "fstype", "mountpoint"}
 
+	// labels of the mount info metric, the mount and parent IDs are empty
+	// when mountinfo can't be read
+	mountInfoLabels = append(fsLabels, "mount_id", "parent_id", "root", "propagation")
+
 	// labels present in all scrape metrics to identify the collector
 	collectorLabels = []string{"collector"}
 
 	// scrapeDurationMetric defines the scrape duration metric
 	//
 	// shared between all metric collectors
-	scrapeDurationMetric = Metric{
-		desc: prometheus.NewDesc(
-			prometheus.BuildFQName(ONDAT_NAMESPACE, SCRAPE_SUBSYSTEM, "collector_duration_seconds"),
-			"Duration of a collector scrape.",
-			collectorLabels, nil,
-		),
-		valueType: prometheus.GaugeValue,
-	}
+	scrapeDurationMetric = newMetric(
+		prometheus.BuildFQName(ONDAT_NAMESPACE, SCRAPE_SUBSYSTEM, "collector_duration_seconds"),
+		"Duration of a collector scrape.",
+		collectorLabels,
+		prometheus.GaugeValue,
+	)
 
 	// scrapeDurationDesc defines the scrape success/failure metric
 	//
 	// shared between all metric collectors
-	scrapeSuccessMetric = Metric{
-		desc: prometheus.NewDesc(
-			prometheus.BuildFQName(ONDAT_NAMESPACE, SCRAPE_SUBSYSTEM, "collector_success"),
-			"Whether a collector succeeded.",
-			collectorLabels, nil,
-		),
-		valueType: prometheus.GaugeValue,
-	}
+	scrapeSuccessMetric = newMetric(
+		prometheus.BuildFQName(ONDAT_NAMESPACE, SCRAPE_SUBSYSTEM, "collector_success"),
+		"Whether a collector succeeded.",
+		collectorLabels,
+		prometheus.GaugeValue,
+	)
 )
 
 // Metric is a wrapper over prometheus types (desc and type) defining a
@@ -67,4 +87,32 @@ var (
 type Metric struct {
 	desc      *prometheus.Desc
 	valueType prometheus.ValueType
+	// histogram is set for histograms, prometheus has no value type for them
+	histogram bool
+
+	// name, help and labels are what desc was built with, a Desc can't be read
+	// back
+	name   string
+	help   string
+	labels []string
+}
+
+func newMetric(name, help string, labels []string, valueType prometheus.ValueType) Metric {
+	return Metric{
+		desc:      prometheus.NewDesc(name, help, labels, nil),
+		valueType: valueType,
+		name:      name,
+		help:      help,
+		labels:    labels,
+	}
+}
+
+func newHistogramMetric(name, help string, labels []string) Metric {
+	return Metric{
+		desc:      prometheus.NewDesc(name, help, labels, nil),
+		histogram: true,
+		name:      name,
+		help:      help,
+		labels:    labels,
+	}
 }
@@ -2,11 +2,10 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -47,52 +46,288 @@ type Master struct {
 // ProcDiskstats reads the diskstats file and returns an array of Diskstats (one
 // per line/device)
 func ProcDiskstats() ([]blockdevice.Diskstats, error) {
-	file, err := os.Open(DISKSTATS_PATH)
+	content, err := readHostFile(DISKSTATS_PATH)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
+	return parseDiskstats(bytes.NewReader(content))
+}
+
+// deviceNumber identifies a block device by its major and minor numbers.
+type deviceNumber struct {
+	major uint32
+	minor uint32
+}
+
+// ProcDiskstatsByDevice reads the diskstats file and returns the rows of the
+// given devices only, keyed by device number.
+func ProcDiskstatsByDevice(devices map[deviceNumber]struct{}) (map[deviceNumber]blockdevice.Diskstats, error) {
+	content, err := readHostFile(DISKSTATS_PATH)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDiskstatsByDevice(content, devices), nil
+}
+
+// parseDiskstats parses content in the /proc/diskstats format. Lines that
+// can't be parsed are skipped, a single malformed line must not prevent the
+// metrics of all other devices from being reported.
+func parseDiskstats(r io.Reader) ([]blockdevice.Diskstats, error) {
 	diskstats := []blockdevice.Diskstats{}
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		d := &blockdevice.Diskstats{}
-		d.IoStatsCount, err = fmt.Sscanf(scanner.Text(), "%d %d %s %d %d %d %d %d %d %d %d %d %d %d %d %d %d %d %d %d",
-			&d.MajorNumber,
-			&d.MinorNumber,
-			&d.DeviceName,
-			&d.ReadIOs,
-			&d.ReadMerges,
-			&d.ReadSectors,
-			&d.ReadTicks,
-			&d.WriteIOs,
-			&d.WriteMerges,
-			&d.WriteSectors,
-			&d.WriteTicks,
-			&d.IOsInProgress,
-			&d.IOsTotalTicks,
-			&d.WeightedIOTicks,
-			&d.DiscardIOs,
-			&d.DiscardMerges,
-			&d.DiscardSectors,
-			&d.DiscardTicks,
-			&d.FlushRequestsCompleted,
-			&d.TimeSpentFlushing,
-		)
-		// The io.EOF error can be safely ignored because it just means we read fewer than
-		// the full 20 fields. Happens on kernel versions lower than 5.5
-		if err != nil && err != io.EOF {
-			return diskstats, err
-		}
-		if d.IoStatsCount >= PROC_DISKSTATS_MIN_NUM_FIELDS {
-			diskstats = append(diskstats, *d)
+		d := blockdevice.Diskstats{}
+		name, ok := parseDiskstatsLine(scanner.Bytes(), &d)
+		if !ok {
+			continue
 		}
+		d.DeviceName = string(name)
+		diskstats = append(diskstats, d)
 	}
 	return diskstats, scanner.Err()
 }
 
+// parseDiskstatsByDevice is parseDiskstats keeping the rows of the given
+// devices only. Rows of other devices, the vast majority on a busy node, are
+// skipped without allocating.
+func parseDiskstatsByDevice(content []byte, devices map[deviceNumber]struct{}) map[deviceNumber]blockdevice.Diskstats {
+	diskstats := make(map[deviceNumber]blockdevice.Diskstats, len(devices))
+	for len(content) > 0 && len(diskstats) < len(devices) {
+		line := content
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			line, content = content[:i], content[i+1:]
+		} else {
+			content = nil
+		}
+		// as bufio.ScanLines does
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+
+		// cheap check of the device number before parsing the whole line
+		major, rest, ok := nextUint(line, 32)
+		if !ok {
+			continue
+		}
+		minor, _, ok := nextUint(rest, 32)
+		if !ok {
+			continue
+		}
+		num := deviceNumber{major: uint32(major), minor: uint32(minor)}
+		if _, ok := devices[num]; !ok {
+			continue
+		}
+		if _, ok := diskstats[num]; ok {
+			continue
+		}
+
+		d := blockdevice.Diskstats{}
+		name, ok := parseDiskstatsLine(line, &d)
+		if !ok {
+			continue
+		}
+		d.DeviceName = string(name)
+		diskstats[num] = d
+	}
+	return diskstats
+}
+
+// parseDiskstatsLine parses a /proc/diskstats row into d, but for the device
+// name returned as is so that callers only allocate it for the rows they keep.
+// Rows with fewer than PROC_DISKSTATS_MIN_NUM_FIELDS fields or invalid ones
+// aren't valid, fields past the 20 known ones are ignored.
+func parseDiskstatsLine(line []byte, d *blockdevice.Diskstats) ([]byte, bool) {
+	major, line, ok := nextUint(line, 32)
+	if !ok {
+		return nil, false
+	}
+	minor, line, ok := nextUint(line, 32)
+	if !ok {
+		return nil, false
+	}
+	name, line := nextField(line)
+	if len(name) == 0 {
+		return nil, false
+	}
+	d.MajorNumber, d.MinorNumber = uint32(major), uint32(minor)
+
+	if !parseIOStats(line, d) {
+		return nil, false
+	}
+	return name, true
+}
+
+// parseIOStats parses the I/O statistics columns shared by /proc/diskstats
+// and /sys/block/<dev>/stat into d. The IoStatsCount of d counts the major,
+// minor and device name columns of /proc/diskstats in both cases, so that
+// callers handle the two sources the same way.
+func parseIOStats(line []byte, d *blockdevice.Diskstats) bool {
+	// order MUST match the columns in the diskstats file
+	stats := [...]*uint64{
+		&d.ReadIOs,
+		&d.ReadMerges,
+		&d.ReadSectors,
+		&d.ReadTicks,
+		&d.WriteIOs,
+		&d.WriteMerges,
+		&d.WriteSectors,
+		&d.WriteTicks,
+		&d.IOsInProgress,
+		&d.IOsTotalTicks,
+		&d.WeightedIOTicks,
+		&d.DiscardIOs,
+		&d.DiscardMerges,
+		&d.DiscardSectors,
+		&d.DiscardTicks,
+		&d.FlushRequestsCompleted,
+		&d.TimeSpentFlushing,
+	}
+	d.IoStatsCount = 3
+	for _, stat := range stats {
+		if len(bytes.TrimLeft(line, " \t\n")) == 0 {
+			break
+		}
+		var ok bool
+		*stat, line, ok = nextUint(line, 64)
+		if !ok {
+			return false
+		}
+		d.IoStatsCount++
+	}
+
+	return d.IoStatsCount >= PROC_DISKSTATS_MIN_NUM_FIELDS
+}
+
+// GetBlockDeviceStats reads the I/O statistics of the given device from
+// /sys/block/<dev>/stat, in the same form as its /proc/diskstats row.
+func GetBlockDeviceStats(device string, major, minor int) (blockdevice.Diskstats, error) {
+	d := blockdevice.Diskstats{}
+	if !isValidDeviceName(device) {
+		return d, fmt.Errorf("invalid device name %q", device)
+	}
+
+	path := "/sys/block/" + device + "/stat"
+	data, err := readHostFile(path)
+	if err != nil {
+		return d, err
+	}
+	if !parseIOStats(data, &d) {
+		return d, fmt.Errorf("unexpected content in %s", path)
+	}
+	d.MajorNumber, d.MinorNumber, d.DeviceName = uint32(major), uint32(minor), device
+	return d, nil
+}
+
+// nextField returns the first whitespace separated field of b and what
+// follows it.
+func nextField(b []byte) ([]byte, []byte) {
+	start := 0
+	for start < len(b) && isSpace(b[start]) {
+		start++
+	}
+	end := start
+	for end < len(b) && !isSpace(b[end]) {
+		end++
+	}
+	return b[start:end], b[end:]
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// nextUint parses the first whitespace separated field of b as a decimal
+// unsigned integer of the given bit size, without allocating.
+func nextUint(b []byte, bitSize uint) (uint64, []byte, bool) {
+	field, rest := nextField(b)
+	if len(field) == 0 {
+		return 0, rest, false
+	}
+
+	max := uint64(1)<<bitSize - 1
+	if bitSize == 64 {
+		max = ^uint64(0)
+	}
+	var n uint64
+	for _, c := range field {
+		if c < '0' || c > '9' {
+			return 0, rest, false
+		}
+		digit := uint64(c - '0')
+		if n > (max-digit)/10 {
+			return 0, rest, false
+		}
+		n = n*10 + digit
+	}
+	return n, rest, true
+}
+
 func GetBlockDeviceLogicalBlockSize(device string) (uint64, error) {
-	data, err := ioutil.ReadFile("/sys/block/" + device + "/queue/logical_block_size")
+	if !isValidDeviceName(device) {
+		return 0, fmt.Errorf("invalid device name %q", device)
+	}
+
+	return readHostUint("/sys/block/" + device + "/queue/logical_block_size")
+}
+
+// GetBlockDeviceInflight returns the number of read and write requests
+// currently in flight on the given device.
+func GetBlockDeviceInflight(device string) (uint64, uint64, error) {
+	if !isValidDeviceName(device) {
+		return 0, 0, fmt.Errorf("invalid device name %q", device)
+	}
+
+	data, err := readHostFile("/sys/block/" + device + "/inflight")
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseInflight(data)
+}
+
+// parseInflight parses the content of /sys/block/<dev>/inflight, the reads
+// then the writes in flight.
+func parseInflight(data []byte) (uint64, uint64, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("expected 2 fields in inflight, got %d", len(fields))
+	}
+
+	reads, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid reads in flight: %w", err)
+	}
+	writes, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid writes in flight: %w", err)
+	}
+	return reads, writes, nil
+}
+
+// GetBlockDeviceName returns the kernel name of the block device with the given
+// major and minor numbers, as found under /sys/block.
+func GetBlockDeviceName(major, minor int) (string, error) {
+	path := fmt.Sprintf("/sys/dev/block/%d:%d/uevent", major, minor)
+	data, err := readHostFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if name := strings.TrimPrefix(line, "DEVNAME="); name != line {
+			if !isValidDeviceName(name) {
+				return "", fmt.Errorf("invalid device name %q in %s", name, path)
+			}
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no device name in %s", path)
+}
+
+// readHostUint reads a sysfs attribute holding a single unsigned integer.
+func readHostUint(path string) (uint64, error) {
+	data, err := readHostFile(path)
 	if err != nil {
 		return 0, err
 	}
@@ -100,11 +335,17 @@ func GetBlockDeviceLogicalBlockSize(device string) (uint64, error) {
 	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
 }
 
+// isValidDeviceName reports whether the given kernel device name can be safely
+// used as a path element under /sys/block.
+func isValidDeviceName(device string) bool {
+	return device != "" && device != "." && device != ".." && !strings.ContainsRune(device, '/')
+}
+
 // ExtractOndatVolumesNumbers parses the output from "ls -l" on the
 // storageos block devices directory further building the list
 // of volume with Major & Minor numbers
 func ExtractOndatVolumesNumbers(log *zap.SugaredLogger, vols []*Volume) error {
-	info, err := os.Stat(STOS_VOLUMES_PATH)
+	info, err := statHostPath(STOS_VOLUMES_PATH)
 	if err != nil {
 		return fmt.Errorf("could not read directory %q: %w", STOS_VOLUMES_PATH, err)
 	}
@@ -112,7 +353,7 @@ func ExtractOndatVolumesNumbers(log *zap.SugaredLogger, vols []*Volume) error {
 		return fmt.Errorf("%q is not a directory", STOS_VOLUMES_PATH)
 	}
 
-	output, err := readOndatVolumes()
+	output, err := listHostOndatVolumes()
 	if err != nil {
 		return err
 	}
@@ -121,7 +362,7 @@ func ExtractOndatVolumesNumbers(log *zap.SugaredLogger, vols []*Volume) error {
 }
 
 func readOndatVolumes() ([]string, error) {
-	outputRaw, err := exec.Command("ls", "-l", STOS_VOLUMES_PATH).Output()
+	outputRaw, err := exec.Command("ls", "-l", hostPath(STOS_VOLUMES_PATH)).Output()
 	if err != nil {
 		return nil, fmt.Errorf("command failed: %w", err)
 	}
@@ -133,10 +374,10 @@ func parseOndatVolumes(log *zap.SugaredLogger, vols []*Volume, input []string) e
 	// exclude first and last elements
 	// first line of `ls -l` shows the total size of blocks on that
 	// dir and the ending "\n" creates an empty element on the array
-	input = input[1 : len(input)-1]
-	if len(input) == 0 {
+	if len(input) < 3 {
 		return nil
 	}
+	input = input[1 : len(input)-1]
 
 	var (
 		// discard is used as a filler for the columns in the output from
@@ -150,7 +391,7 @@ func parseOndatVolumes(log *zap.SugaredLogger, vols []*Volume, input []string) e
 
 	for _, line := range input {
 		// only interested in block devices
-		if line[0] != 'b' {
+		if !strings.HasPrefix(line, "b") {
 			continue
 		}
 
@@ -163,8 +404,14 @@ func parseOndatVolumes(log *zap.SugaredLogger, vols []*Volume, input []string) e
 			continue
 		}
 
+		_, volID, found := strings.Cut(deviceName, ".")
+		if !found {
+			log.Warnf("unexpected device name in output of ls, raw line: %s", line)
+			continue
+		}
+
 		for _, vol := range vols {
-			if vol.Master.VolumeID == strings.Split(deviceName, ".")[1] {
+			if vol.Master.VolumeID == volID {
 				vol.Major = major
 				vol.Minor = minor
 			}
@@ -175,7 +422,7 @@ func parseOndatVolumes(log *zap.SugaredLogger, vols []*Volume, input []string) e
 }
 
 func GetVolumesFromLocalState(log *zap.SugaredLogger) ([]*Volume, error) {
-	fsdir, err := os.ReadDir(STOS_VOLUMES_STATE_PATH)
+	fsdir, err := readHostDir(STOS_VOLUMES_STATE_PATH)
 	if err != nil {
 		return nil, err
 	}
@@ -184,22 +431,18 @@ func GetVolumesFromLocalState(log *zap.SugaredLogger) ([]*Volume, error) {
 
 	for _, dir := range fsdir {
 		// skip presentations
-		if dir.Name()[0] == 'd' {
+		if strings.HasPrefix(dir.Name(), "d") {
 			continue
 		}
 
 		filePath := STOS_VOLUMES_STATE_PATH + dir.Name()
-		file, err := os.Open(filePath)
+		content, err := readHostFile(filePath)
 		if err != nil {
-			log.Errorf("failed to open volume state file %s, error: %s", filePath, err)
+			log.Errorf("failed to read volume state file %s, error: %s", filePath, err)
 			continue
 		}
-		defer file.Close()
-
-		content, _ := ioutil.ReadAll(file)
 
-		vol := &Volume{}
-		err = json.Unmarshal([]byte(content), vol)
+		vol, err := parseVolumeState(content)
 		if err != nil {
 			log.Errorf("failed to parse volume state file %s, error: %s", filePath, err)
 			continue
@@ -209,3 +452,12 @@ func GetVolumesFromLocalState(log *zap.SugaredLogger) ([]*Volume, error) {
 	}
 	return result, nil
 }
+
+// parseVolumeState decodes the content of a volume state file.
+func parseVolumeState(content []byte) (*Volume, error) {
+	vol := &Volume{}
+	if err := json.Unmarshal(content, vol); err != nil {
+		return nil, err
+	}
+	return vol, nil
+}
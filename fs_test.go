@@ -1,9 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/prometheus/procfs/blockdevice"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
@@ -96,6 +101,37 @@ brw-rw---- 1 root disk      8, 48 Feb 25 15:18 v.78e88095-e690-49be-b0f3-3f735ef
 			expectedVolumes: []*Volume{},
 			expectedErr:     nil,
 		},
+		{
+			name:     "empty output",
+			lsOutput: ``,
+			volumes:  []*Volume{},
+
+			expectedVolumes: []*Volume{},
+			expectedErr:     nil,
+		},
+		{
+			name: "blank line and device name without volume ID",
+			lsOutput: `total 0
+
+brw-rw---- 1 root disk      8, 32 Feb 25 16:07 c3561d79-459f-4e5d-b5bb-f71ae7b38672
+`,
+			volumes: []*Volume{
+				{
+					Master: Master{
+						VolumeID: "c3561d79-459f-4e5d-b5bb-f71ae7b38672",
+					},
+				},
+			},
+
+			expectedVolumes: []*Volume{
+				{
+					Master: Master{
+						VolumeID: "c3561d79-459f-4e5d-b5bb-f71ae7b38672",
+					},
+				},
+			},
+			expectedErr: nil,
+		},
 		{
 			name: "unexpected value in input, invalid minor number",
 			lsOutput: `total 262144
@@ -129,3 +165,310 @@ brw-rw---- 1 root disk      8, 48 Feb 25 15:18 v.78e88095-e690-49be-b0f3-3f735ef
 		})
 	}
 }
+
+// fuzzSeeds returns the content of the given file of every fixture host, to be
+// used as a fuzzing seed corpus.
+func fuzzSeeds(f testing.TB, path string) []string {
+	f.Helper()
+
+	files, err := filepath.Glob(filepath.Join("testdata", "hosts", "*", path))
+	require.NoError(f, err)
+
+	seeds := make([]string, 0, len(files))
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		require.NoError(f, err)
+		seeds = append(seeds, string(content))
+	}
+	return seeds
+}
+
+func FuzzParseOndatVolumes(f *testing.F) {
+	for _, seed := range fuzzSeeds(f, "volumes.ls") {
+		f.Add(seed)
+	}
+
+	log := zap.NewNop().Sugar()
+	f.Fuzz(func(t *testing.T, lsOutput string) {
+		vols := []*Volume{{Master: Master{VolumeID: "c3561d79-459f-4e5d-b5bb-f71ae7b38672"}}}
+		require.NoError(t, parseOndatVolumes(log, vols, strings.Split(lsOutput, "\n")))
+	})
+}
+
+func FuzzParseDiskstats(f *testing.F) {
+	for _, seed := range fuzzSeeds(f, "root/proc/diskstats") {
+		f.Add(seed)
+	}
+	f.Add("8 0 sda 1 2 3")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		diskstats, err := parseDiskstats(strings.NewReader(content))
+		if err != nil {
+			return
+		}
+		devices := map[deviceNumber]struct{}{}
+		first := map[deviceNumber]blockdevice.Diskstats{}
+		for _, d := range diskstats {
+			num := deviceNumber{major: d.MajorNumber, minor: d.MinorNumber}
+			devices[num] = struct{}{}
+			if _, ok := first[num]; !ok {
+				first[num] = d
+			}
+		}
+		require.Equal(t, first, parseDiskstatsByDevice([]byte(content), devices))
+
+		for _, d := range diskstats {
+			require.GreaterOrEqual(t, d.IoStatsCount, PROC_DISKSTATS_MIN_NUM_FIELDS)
+			require.LessOrEqual(t, d.IoStatsCount, 20)
+			require.NotEmpty(t, d.DeviceName)
+			require.NotContains(t, d.DeviceName, " ")
+
+			// device names end up in sysfs paths
+			if !isValidDeviceName(d.DeviceName) {
+				_, err := GetBlockDeviceLogicalBlockSize(d.DeviceName)
+				require.Error(t, err, d.DeviceName)
+			}
+		}
+
+		// the kept lines are well-formed, they read back the same
+		again, err := parseDiskstats(strings.NewReader(formatDiskstats(diskstats)))
+		require.NoError(t, err)
+		require.Equal(t, diskstats, again)
+	})
+}
+
+// formatDiskstats returns the given stats in the /proc/diskstats format.
+func formatDiskstats(diskstats []blockdevice.Diskstats) string {
+	var b strings.Builder
+	for _, d := range diskstats {
+		fmt.Fprintf(&b, "%d %d %s", d.MajorNumber, d.MinorNumber, d.DeviceName)
+		stats := []uint64{
+			d.ReadIOs, d.ReadMerges, d.ReadSectors, d.ReadTicks,
+			d.WriteIOs, d.WriteMerges, d.WriteSectors, d.WriteTicks,
+			d.IOsInProgress, d.IOsTotalTicks, d.WeightedIOTicks,
+			d.DiscardIOs, d.DiscardMerges, d.DiscardSectors, d.DiscardTicks,
+			d.FlushRequestsCompleted, d.TimeSpentFlushing,
+		}
+		for _, stat := range stats[:d.IoStatsCount-3] {
+			fmt.Fprintf(&b, " %d", stat)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func FuzzParseVolumeState(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("testdata", "hosts", "*", "root", STOS_VOLUMES_STATE_PATH, "*"))
+	require.NoError(f, err)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		require.NoError(f, err)
+		f.Add(content)
+	}
+	f.Add([]byte(`{"master": null, "labels": 3}`))
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		vol, err := parseVolumeState(content)
+		if err != nil {
+			require.Nil(t, vol)
+			return
+		}
+		require.NotNil(t, vol)
+	})
+}
+
+// parseDiskstatsSscanf is the former fmt.Sscanf based parser, kept as a
+// reference for the hand-rolled one.
+func parseDiskstatsSscanf(content string) []blockdevice.Diskstats {
+	diskstats := []blockdevice.Diskstats{}
+	for _, line := range strings.Split(content, "\n") {
+		d := blockdevice.Diskstats{}
+		var err error
+		d.IoStatsCount, err = fmt.Sscanf(line, "%d %d %s %d %d %d %d %d %d %d %d %d %d %d %d %d %d %d %d %d",
+			&d.MajorNumber, &d.MinorNumber, &d.DeviceName,
+			&d.ReadIOs, &d.ReadMerges, &d.ReadSectors, &d.ReadTicks,
+			&d.WriteIOs, &d.WriteMerges, &d.WriteSectors, &d.WriteTicks,
+			&d.IOsInProgress, &d.IOsTotalTicks, &d.WeightedIOTicks,
+			&d.DiscardIOs, &d.DiscardMerges, &d.DiscardSectors, &d.DiscardTicks,
+			&d.FlushRequestsCompleted, &d.TimeSpentFlushing,
+		)
+		if err != nil && err != io.EOF {
+			continue
+		}
+		if d.IoStatsCount >= PROC_DISKSTATS_MIN_NUM_FIELDS {
+			diskstats = append(diskstats, d)
+		}
+	}
+	return diskstats
+}
+
+func TestParseDiskstats(t *testing.T) {
+	for _, content := range fuzzSeeds(t, "root/proc/diskstats") {
+		got, err := parseDiskstats(strings.NewReader(content))
+		require.NoError(t, err)
+		require.Equal(t, parseDiskstatsSscanf(content), got)
+	}
+
+	got, err := parseDiskstats(strings.NewReader("8 0 sda 1 2 3\n" +
+		"8 16 sdb 1 2 3 4 5 6 7 x 9 10 11\n" +
+		"4294967296 0 sdc 1 2 3 4 5 6 7 8 9 10 11\n" +
+		"\t8  32\tsdd 1 2 3 4 5 6 7 8 9 10 11 \n" +
+		"8 48 sde 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19\n"))
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, "sdd", got[0].DeviceName)
+	require.Equal(t, 14, got[0].IoStatsCount)
+	require.Equal(t, "sde", got[1].DeviceName)
+	require.Equal(t, 20, got[1].IoStatsCount)
+	require.Equal(t, uint64(17), got[1].TimeSpentFlushing)
+}
+
+func TestParseDiskstatsByDevice(t *testing.T) {
+	content := []byte(syntheticDiskstats(1000))
+	devices := map[deviceNumber]struct{}{
+		{major: 252, minor: 10}:  {},
+		{major: 252, minor: 999}: {},
+		{major: 9, minor: 9}:     {},
+	}
+
+	got := parseDiskstatsByDevice(content, devices)
+	require.Len(t, got, 2)
+	require.Equal(t, "dm-10", got[deviceNumber{major: 252, minor: 10}].DeviceName)
+	require.Equal(t, uint64(999), got[deviceNumber{major: 252, minor: 999}].ReadIOs)
+
+	// only the kept rows allocate
+	allocs := testing.AllocsPerRun(10, func() {
+		parseDiskstatsByDevice(content, map[deviceNumber]struct{}{{major: 9, minor: 9}: {}})
+	})
+	require.LessOrEqual(t, allocs, 1.0)
+}
+
+func TestParseInflight(t *testing.T) {
+	tests := []struct {
+		content string
+		reads   uint64
+		writes  uint64
+		wantErr bool
+	}{
+		{content: "       0        0\n"},
+		{content: "       3       12\n", reads: 3, writes: 12},
+		{content: "18446744073709551615 1", reads: 18446744073709551615, writes: 1},
+		{content: "", wantErr: true},
+		{content: "\n", wantErr: true},
+		{content: "3\n", wantErr: true},
+		{content: "3 12 7\n", wantErr: true},
+		{content: "x 12\n", wantErr: true},
+		{content: "3 x\n", wantErr: true},
+		{content: "-3 12\n", wantErr: true},
+		{content: "3 18446744073709551616\n", wantErr: true},
+		{content: "3.0 12\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		reads, writes, err := parseInflight([]byte(tt.content))
+		if tt.wantErr {
+			require.Error(t, err, tt.content)
+			continue
+		}
+		require.NoError(t, err, tt.content)
+		require.Equal(t, tt.reads, reads, tt.content)
+		require.Equal(t, tt.writes, writes, tt.content)
+	}
+}
+
+// syntheticDiskstats returns a /proc/diskstats of a dense node with the given
+// number of device mapper devices.
+func syntheticDiskstats(devices int) string {
+	var b strings.Builder
+	for i := 0; i < devices; i++ {
+		fmt.Fprintf(&b, "%4d %7d loop%d 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n", 7, i, i)
+		fmt.Fprintf(&b, "%4d %7d dm-%d %d 0 %d 1234 5678 9 %d 8765 0 4321 9999 0 0 0 0 12 34\n", 252, i, i, i, i*8, i*16)
+	}
+	return b.String()
+}
+
+// syntheticVolumes returns the given number of Ondat volumes backed by the
+// device mapper devices of syntheticDiskstats.
+func syntheticVolumes(n int) []*Volume {
+	vols := make([]*Volume, 0, n)
+	for i := 0; i < n; i++ {
+		vols = append(vols, &Volume{
+			Major:  252,
+			Minor:  i * 2,
+			Master: Master{VolumeID: fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)},
+		})
+	}
+	return vols
+}
+
+// BenchmarkDiskstatsMatching compares matching a few hundred Ondat volumes
+// with the diskstats rows of a dense node, as the diskstats collector does.
+func BenchmarkDiskstatsMatching(b *testing.B) {
+	content := syntheticDiskstats(2000)
+	vols := syntheticVolumes(500)
+
+	b.Run("sscanf nested loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			diskstats := parseDiskstatsSscanf(content)
+			for _, vol := range vols {
+				for _, stats := range diskstats {
+					if vol.Major == int(stats.MajorNumber) && vol.Minor == int(stats.MinorNumber) {
+						break
+					}
+				}
+			}
+		}
+	})
+
+	b.Run("indexed", func(b *testing.B) {
+		b.ReportAllocs()
+		raw := []byte(content)
+		for n := 0; n < b.N; n++ {
+			devices := make(map[deviceNumber]struct{}, len(vols))
+			for _, vol := range vols {
+				devices[deviceNumber{major: uint32(vol.Major), minor: uint32(vol.Minor)}] = struct{}{}
+			}
+			diskstats := parseDiskstatsByDevice(raw, devices)
+			for _, vol := range vols {
+				_ = diskstats[deviceNumber{major: uint32(vol.Major), minor: uint32(vol.Minor)}]
+			}
+		}
+	})
+}
+
+// BenchmarkMountMatching compares matching the mounts of a dense node with the
+// Ondat volumes, as the filesystem collector does.
+func BenchmarkMountMatching(b *testing.B) {
+	vols := syntheticVolumes(500)
+	mounts := make([]string, 0, len(vols))
+	for _, vol := range vols {
+		mounts = append(mounts, STOS_VOLUMES_PATH+"/v."+vol.Master.VolumeID)
+	}
+
+	b.Run("nested loop", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, device := range mounts {
+				volID, _ := mountedVolumeID(device)
+				for _, vol := range vols {
+					if vol.Master.VolumeID == volID {
+						break
+					}
+				}
+			}
+		}
+	})
+
+	b.Run("indexed", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			volumes := make(map[string]*Volume, len(vols))
+			for _, vol := range vols {
+				volumes[vol.Master.VolumeID] = vol
+			}
+			for _, device := range mounts {
+				volID, _ := mountedVolumeID(device)
+				_ = volumes[volID]
+			}
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the fixture hosts")
+
+// useFixtureHost points the collectors at the fixture host in dir for the
+// duration of the test. Fixture hosts are laid out as support bundles:
+//   - root/: the fake host tree
+//   - volumes.ls: "ls -l" output of the Ondat block devices directory
+//   - statfs.json: statfs() results keyed by mount point
+//   - metrics.golden: the expected metrics
+func useFixtureHost(t *testing.T, dir string) {
+	t.Helper()
+
+	restore, err := useHost(dir)
+	require.NoError(t, err)
+	t.Cleanup(restore)
+}
+
+// fixtureHosts returns the directories of all the fixture hosts.
+func fixtureHosts(t *testing.T) []string {
+	t.Helper()
+
+	hosts, err := filepath.Glob(filepath.Join("testdata", "hosts", "*"))
+	require.NoError(t, err)
+	require.NotEmpty(t, hosts)
+	return hosts
+}
+
+func TestGoldenHosts(t *testing.T) {
+	for _, dir := range fixtureHosts(t) {
+		var dir = dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			// no t.Parallel(), the fixture host is set through package variables
+			useFixtureHost(t, dir)
+
+			log := zap.NewNop().Sugar()
+			got, err := gatherExposition(NewCollectorGroup(log, GetEnabledMetricsCollectors(log, configondatv1.MetricsExporterConfigSpec{})))
+			require.NoError(t, err)
+
+			golden := filepath.Join(dir, BUNDLE_METRICS_FILE)
+			if *update {
+				require.NoError(t, ioutil.WriteFile(golden, got, 0644))
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(want), string(got), "exposition differs from %s, run with -update to regenerate", golden)
+		})
+	}
+}
+
+// TestRegisterAllCollectors checks that the descs of all the collectors,
+// the opt-in ones included, can be registered side by side.
+func TestRegisterAllCollectors(t *testing.T) {
+	useFixtureHost(t, filepath.Join("testdata", "hosts", "kernel-5.5"))
+
+	previous := quotactl
+	t.Cleanup(func() { quotactl = previous })
+	quotactl = func(uint32, string, uint32, unsafe.Pointer) error { return unix.ESRCH }
+
+	log := zap.NewNop().Sugar()
+	collectors := newMetricsCollectors(log, referenceConfigSpec, allKernelFeatures)
+	// the 6 default ones, the sampler and the quota collectors
+	require.Len(t, collectors, 8)
+	group := NewCollectorGroup(log, collectors)
+	t.Cleanup(group.Close)
+
+	_, err := gatherExposition(group)
+	require.NoError(t, err)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// hostRoot is the directory all host paths are resolved against. It is "/"
+// when running on a node and is only changed to run the collectors against a
+// fake host tree (tests, replay of a support bundle).
+var hostRoot = "/"
+
+// statfs is the syscall used to gather filesystem statistics. It is a variable
+// so that its results can be injected when the host tree is not a real one.
+var statfs = unix.Statfs
+
+// listOndatVolumes returns the "ls -l" output of the Ondat block devices
+// directory, one line per element. Device nodes can't be faked on a fixture
+// tree, so the listing is injectable as well.
+var listOndatVolumes = readOndatVolumes
+
+// recorder, when set, records every host input read by the collectors so
+// that they can be written into a support bundle.
+var recorder *bundleRecorder
+
+// hostPath returns the given absolute host path resolved against hostRoot.
+func hostPath(path string) string {
+	return filepath.Join(hostRoot, path)
+}
+
+// readHostFile reads the given absolute host path. All host files are read
+// through it so that they can be recorded.
+func readHostFile(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(hostPath(path))
+	if err == nil && recorder != nil {
+		recorder.addFile(path, content)
+	}
+	return content, err
+}
+
+// readHostDir lists the given absolute host directory.
+func readHostDir(path string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(hostPath(path))
+	if err == nil && recorder != nil {
+		recorder.addDir(path)
+	}
+	return entries, err
+}
+
+// readHostLinks returns the names of the entries of the given absolute host
+// directory of sysfs links, such as /sys/block/<dev>/holders. Unlike
+// readHostDir, the entries themselves are recorded as the links are all there
+// is to read.
+func readHostLinks(path string) ([]string, error) {
+	entries, err := os.ReadDir(hostPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		if recorder != nil {
+			recorder.addDir(filepath.Join(path, entry.Name()))
+		}
+	}
+	if recorder != nil {
+		recorder.addDir(path)
+	}
+	return names, nil
+}
+
+// statHostPath returns the file info of the given absolute host path.
+func statHostPath(path string) (os.FileInfo, error) {
+	info, err := os.Stat(hostPath(path))
+	if err == nil && info.IsDir() && recorder != nil {
+		recorder.addDir(path)
+	}
+	return info, err
+}
+
+// hostStatfs calls statfs() on the given host mount point.
+func hostStatfs(path string, buf *unix.Statfs_t) error {
+	err := statfs(path, buf)
+	if recorder != nil {
+		recorder.addStatfs(path, buf, err)
+	}
+	return err
+}
+
+// listHostOndatVolumes returns the "ls -l" output of the host's Ondat block
+// devices directory.
+func listHostOndatVolumes() ([]string, error) {
+	output, err := listOndatVolumes()
+	if err == nil && recorder != nil {
+		recorder.addVolumesListing(output)
+	}
+	return output, err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jbd2Phases are the phases of a journal commit averaged in jbd2 info files,
+// keyed by their description.
+var jbd2Phases = map[string]string{
+	"waiting for transaction":         "waiting",
+	"request delay":                   "request_delay",
+	"running transaction":             "running",
+	"transaction was being locked":    "locked",
+	"flushing data (in ordered mode)": "flushing",
+	"logging transaction":             "logging",
+}
+
+// jbd2Info are the statistics of a jbd2 journal, from /proc/fs/jbd2/<dev>-8/info.
+type jbd2Info struct {
+	transactions          uint64
+	requestedTransactions uint64
+	maxTransactionBlocks  uint64
+
+	// the averages are only reported once a transaction was committed
+	hasAverages bool
+	// phases are the average durations of the commit phases, by phase
+	phases                     map[string]time.Duration
+	commitTime                 time.Duration
+	handlesPerTransaction      uint64
+	blocksPerTransaction       uint64
+	loggedBlocksPerTransaction uint64
+}
+
+// parseJBD2Info parses the content of a jbd2 info file:
+//
+//	127 transactions (125 requested), each up to 8192 blocks
+//	average:
+//	  0ms waiting for transaction
+//	  ...
+//	  2460us average transaction commit time
+//	  43 handles per transaction
+//	  9 blocks per transaction
+//	  10 logged blocks per transaction
+func parseJBD2Info(r io.Reader) (jbd2Info, error) {
+	info := jbd2Info{phases: map[string]time.Duration{}}
+
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return info, err
+		}
+		return info, fmt.Errorf("empty jbd2 info")
+	}
+	// the requested transactions are missing before 3.x kernels
+	header := scanner.Text()
+	if _, err := fmt.Sscanf(header, "%d transactions (%d requested), each up to %d blocks", &info.transactions, &info.requestedTransactions, &info.maxTransactionBlocks); err != nil {
+		if _, err := fmt.Sscanf(header, "%d transactions, each up to %d blocks", &info.transactions, &info.maxTransactionBlocks); err != nil {
+			return info, fmt.Errorf("malformed jbd2 info header %q: %w", header, err)
+		}
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "average:" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return info, fmt.Errorf("malformed jbd2 info line %q", line)
+		}
+		value, description := parts[0], parts[1]
+
+		var err error
+		switch description {
+		case "average transaction commit time":
+			info.commitTime, err = time.ParseDuration(value)
+		case "handles per transaction":
+			info.handlesPerTransaction, err = strconv.ParseUint(value, 10, 64)
+		case "blocks per transaction":
+			info.blocksPerTransaction, err = strconv.ParseUint(value, 10, 64)
+		case "logged blocks per transaction":
+			info.loggedBlocksPerTransaction, err = strconv.ParseUint(value, 10, 64)
+		default:
+			phase, ok := jbd2Phases[description]
+			if !ok {
+				// unknown statistic of a newer kernel
+				continue
+			}
+			info.phases[phase], err = time.ParseDuration(value)
+		}
+		if err != nil {
+			return info, fmt.Errorf("invalid value in jbd2 info line %q: %w", line, err)
+		}
+		info.hasAverages = true
+	}
+
+	return info, scanner.Err()
+}
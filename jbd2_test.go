@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJBD2Info(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected jbd2Info
+	}{
+		{
+			name: "committed transactions",
+			content: `3841 transactions (3702 requested), each up to 8192 blocks
+average:
+  0ms waiting for transaction
+  0ms request delay
+  12ms running transaction
+  0ms transaction was being locked
+  0ms flushing data (in ordered mode)
+  4ms logging transaction
+  5230us average transaction commit time
+  71 handles per transaction
+  12 blocks per transaction
+  14 logged blocks per transaction
+`,
+			expected: jbd2Info{
+				transactions:          3841,
+				requestedTransactions: 3702,
+				maxTransactionBlocks:  8192,
+				hasAverages:           true,
+				phases: map[string]time.Duration{
+					"waiting":       0,
+					"request_delay": 0,
+					"running":       12 * time.Millisecond,
+					"locked":        0,
+					"flushing":      0,
+					"logging":       4 * time.Millisecond,
+				},
+				commitTime:                 5230 * time.Microsecond,
+				handlesPerTransaction:      71,
+				blocksPerTransaction:       12,
+				loggedBlocksPerTransaction: 14,
+			},
+		},
+		{
+			name:    "no transaction",
+			content: "0 transactions (0 requested), each up to 8192 blocks\n",
+			expected: jbd2Info{
+				maxTransactionBlocks: 8192,
+				phases:               map[string]time.Duration{},
+			},
+		},
+		{
+			name: "old kernel",
+			content: `12 transactions, each up to 2048 blocks
+average:
+  1ms waiting for transaction
+  3ms running transaction
+  0ms transaction was being locked
+  0ms flushing data (in ordered mode)
+  2ms logging transaction
+  2100us average transaction commit time
+  9 handles per transaction
+  4 blocks per transaction
+  6 logged blocks per transaction
+`,
+			expected: jbd2Info{
+				transactions:         12,
+				maxTransactionBlocks: 2048,
+				hasAverages:          true,
+				phases: map[string]time.Duration{
+					"waiting":  time.Millisecond,
+					"running":  3 * time.Millisecond,
+					"locked":   0,
+					"flushing": 0,
+					"logging":  2 * time.Millisecond,
+				},
+				commitTime:                 2100 * time.Microsecond,
+				handlesPerTransaction:      9,
+				blocksPerTransaction:       4,
+				loggedBlocksPerTransaction: 6,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseJBD2Info(strings.NewReader(tt.content))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, info)
+		})
+	}
+}
+
+func TestParseJBD2InfoMalformed(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"transactions, each up to 8192 blocks\n",
+		"12 transactions (3 requested), each up to 8192 blocks\naverage:\n  xms logging transaction\n",
+		"12 transactions (3 requested), each up to 8192 blocks\naverage:\n  garbage\n",
+	} {
+		_, err := parseJBD2Info(strings.NewReader(content))
+		require.Error(t, err, content)
+	}
+}
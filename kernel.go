@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/procfs/blockdevice"
+	"go.uber.org/zap"
+)
+
+const (
+	KERNEL_RELEASE_PATH = "/proc/sys/kernel/osrelease"
+
+	// number of /proc/diskstats columns of the kernels adding the discard
+	// (v4.18) then the flush (v5.5) stats
+	DISKSTATS_DISCARD_NUM_FIELDS = 18
+	DISKSTATS_FLUSH_NUM_FIELDS   = 20
+)
+
+// kernelFeatures are the optional kernel features the collectors depend on.
+type kernelFeatures struct {
+	// release of the running kernel, empty when unknown
+	release string
+	// diskstatsColumns is the number of columns of /proc/diskstats rows,
+	// counting the major, minor and device name ones
+	diskstatsColumns int
+	// bdiStats is whether the writeback stats of the backing devices can be
+	// read, from sysfs or a mounted debugfs
+	bdiStats bool
+}
+
+// allKernelFeatures are the features of the most recent kernels, to describe
+// every metric the exporter may expose whatever the host.
+var allKernelFeatures = kernelFeatures{diskstatsColumns: DISKSTATS_FLUSH_NUM_FIELDS, bdiStats: true}
+
+func (f kernelFeatures) discardStats() bool {
+	return f.diskstatsColumns >= DISKSTATS_DISCARD_NUM_FIELDS
+}
+
+func (f kernelFeatures) flushStats() bool {
+	return f.diskstatsColumns >= DISKSTATS_FLUSH_NUM_FIELDS
+}
+
+// detectKernelFeatures finds out the features of the running kernel. The
+// diskstats columns are counted on the host, as distributions backport them,
+// and only inferred from the kernel version when no stats can be read.
+func detectKernelFeatures(log *zap.SugaredLogger) kernelFeatures {
+	features := kernelFeatures{}
+
+	release, err := readHostFile(KERNEL_RELEASE_PATH)
+	if err != nil {
+		log.Warnw("could not read kernel release", "error", err)
+	} else {
+		features.release = strings.TrimSpace(string(release))
+	}
+
+	features.diskstatsColumns = detectDiskstatsColumns(log)
+	if features.diskstatsColumns == 0 {
+		features.diskstatsColumns = diskstatsColumnsOfRelease(features.release)
+		log.Infow("could not count diskstats columns, inferred them from the kernel release", "release", features.release, "columns", features.diskstatsColumns)
+	}
+
+	features.bdiStats = detectBDIStats(log)
+
+	log.Infow("detected kernel features", "release", features.release, "diskstats_columns", features.diskstatsColumns,
+		"discard_stats", features.discardStats(), "flush_stats", features.flushStats(), "bdi_stats", features.bdiStats)
+	return features
+}
+
+// detectDiskstatsColumns counts the columns of the first valid row of
+// /proc/diskstats, or of the first /sys/block/<dev>/stat file when procfs is
+// restricted. It returns 0 when neither can be read.
+func detectDiskstatsColumns(log *zap.SugaredLogger) int {
+	content, err := readHostFile(DISKSTATS_PATH)
+	if err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			d := blockdevice.Diskstats{}
+			if _, ok := parseDiskstatsLine(scanner.Bytes(), &d); ok {
+				return d.IoStatsCount
+			}
+		}
+	}
+	log.Debugw("no valid diskstats row, trying sysfs", "error", err)
+
+	entries, err := readHostDir("/sys/block")
+	if err != nil {
+		log.Debugw("could not list block devices", "error", err)
+		return 0
+	}
+	for _, entry := range entries {
+		data, err := readHostFile("/sys/block/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		d := blockdevice.Diskstats{}
+		if parseIOStats(data, &d) {
+			return d.IoStatsCount
+		}
+	}
+	return 0
+}
+
+// detectBDIStats reports whether the stats of any backing device can be read,
+// mainline kernels only have them in debugfs which is seldom mounted in the
+// exporter's pod.
+func detectBDIStats(log *zap.SugaredLogger) bool {
+	for _, dir := range []string{"/sys/class/bdi", "/sys/kernel/debug/bdi"} {
+		entries, err := readHostDir(dir)
+		if err != nil {
+			log.Debugw("could not list backing devices", "path", dir, "error", err)
+			continue
+		}
+		for _, entry := range entries {
+			if _, err := readHostFile(dir + "/" + entry.Name() + "/stats"); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// diskstatsColumnsOfRelease returns the number of /proc/diskstats columns of
+// mainline kernels of the given release, e.g. "5.4.0-104-generic". Unknown
+// releases are assumed recent.
+func diskstatsColumnsOfRelease(release string) int {
+	fields := strings.SplitN(release, ".", 3)
+	if len(fields) < 2 {
+		return DISKSTATS_FLUSH_NUM_FIELDS
+	}
+	major, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return DISKSTATS_FLUSH_NUM_FIELDS
+	}
+	// "15-rc1" in "5.15-rc1"
+	minorField := fields[1]
+	if i := strings.IndexFunc(minorField, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minorField = minorField[:i]
+	}
+	minor, err := strconv.Atoi(minorField)
+	if err != nil {
+		return DISKSTATS_FLUSH_NUM_FIELDS
+	}
+
+	switch {
+	case major > 5 || major == 5 && minor >= 5:
+		return DISKSTATS_FLUSH_NUM_FIELDS
+	case major == 5 || major == 4 && minor >= 18:
+		return DISKSTATS_DISCARD_NUM_FIELDS
+	default:
+		return PROC_DISKSTATS_MIN_NUM_FIELDS
+	}
+}
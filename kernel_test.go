@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDiskstatsColumnsOfRelease(t *testing.T) {
+	for release, expected := range map[string]int{
+		"4.14.0-1-amd64":            PROC_DISKSTATS_MIN_NUM_FIELDS,
+		"3.10.0-1160.el7.x86_64":    PROC_DISKSTATS_MIN_NUM_FIELDS,
+		"4.18.0-25-generic":         DISKSTATS_DISCARD_NUM_FIELDS,
+		"5.4.0-104-generic":         DISKSTATS_DISCARD_NUM_FIELDS,
+		"5.5.0-1.el8.elrepo.x86_64": DISKSTATS_FLUSH_NUM_FIELDS,
+		"5.15-rc1":                  DISKSTATS_FLUSH_NUM_FIELDS,
+		"6.1.0":                     DISKSTATS_FLUSH_NUM_FIELDS,
+		"":                          DISKSTATS_FLUSH_NUM_FIELDS,
+		"unknown":                   DISKSTATS_FLUSH_NUM_FIELDS,
+	} {
+		require.Equal(t, expected, diskstatsColumnsOfRelease(release), release)
+	}
+}
+
+func TestDetectBDIStats(t *testing.T) {
+	log := zap.NewNop().Sugar()
+	for host, expected := range map[string]bool{
+		"kernel-5.5":  true,
+		"kernel-4.14": false,
+	} {
+		useFixtureHost(t, filepath.Join("testdata", "hosts", host))
+		require.Equal(t, expected, detectBDIStats(log), host)
+	}
+}
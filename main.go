@@ -17,10 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -47,22 +52,27 @@ func init() {
 	utilruntime.Must(configondatv1.AddToScheme(scheme))
 }
 
-func main() {
-	configFile, cfg := getConfigOrDie()
+// subcommands maps the name of each subcommand to its entrypoint, which gets
+// the remaining arguments and returns the exit code. Without a subcommand the
+// exporter serves metrics.
+var subcommands = map[string]func(args []string) int{
+	"capture":  runCapture,
+	"diagnose": runDiagnose,
+	"docs":     runDocs,
+}
 
-	level, err := zapcore.ParseLevel(cfg.LogLevel)
-	if err != nil {
-		log.Printf("failed to parse log level %s: %s\n", cfg.LogLevel, err.Error())
-		os.Exit(1)
+func main() {
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(subcommand(os.Args[2:]))
+		}
 	}
 
-	loggerConfig := zap.NewProductionConfig()
-	loggerConfig.EncoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
-	loggerConfig.Level.SetLevel(level)
+	configFile, cfg := getConfigOrDie()
 
-	logger, err := loggerConfig.Build()
+	logger, err := newLogger(cfg.LogLevel)
 	if err != nil {
-		log.Printf("failed to build logger from desired config: %s\n", err.Error())
+		log.Printf("failed to build logger: %s\n", err.Error())
 		os.Exit(1)
 	}
 	defer func() { _ = logger.Sync() }()
@@ -73,13 +83,25 @@ func main() {
 	}
 	log.Debugf("Serve metrics timeout set to %d seconds", cfg.Timeout)
 
-	metricsCollectors := GetEnabledMetricsCollectors(log, cfg.DisabledCollectors)
+	if len(*replayFlag) > 0 {
+		cleanup, err := replayBundle(*replayFlag)
+		if err != nil {
+			log.Fatalw("failed to replay support bundle", "bundle", *replayFlag, "error", err)
+		}
+		defer cleanup()
+		log.Infow("serving metrics from support bundle", "bundle", *replayFlag)
+	}
+
+	metricsCollectors := GetEnabledMetricsCollectors(log, cfg.MetricsExporterConfigSpec)
 	if len(metricsCollectors) == 0 {
 		log.Fatal("there is nothing to do with all metrics collectors disabled")
 	}
 
 	prometheusRegistry := prometheus.NewRegistry()
-	_ = prometheusRegistry.Register(NewCollectorGroup(log, metricsCollectors))
+	collectorGroup := NewCollectorGroup(log, metricsCollectors)
+	if err := prometheusRegistry.Register(collectorGroup); err != nil {
+		log.Fatalw("failed to register collectors", "error", err)
+	}
 
 	// k8s endpoints
 	http.HandleFunc("/healthz", healthz)
@@ -111,11 +133,37 @@ func main() {
 		w.Header().Set("Content-Type", "text/html")
 	}))
 
+	// stop serving on termination, then the collectors running in the
+	// background
+	server := &http.Server{Addr: address}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Info("shutting down http handler")
+		_ = server.Shutdown(context.Background())
+	}()
+
 	log.Infow("starting http handler", "port", address)
-	if err := http.ListenAndServe(address, nil); err != nil {
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Errorw("error running http server", "error", err)
 		os.Exit(1)
 	}
+	collectorGroup.Close()
+}
+
+// newLogger builds the logger used across the exporter with the given level.
+func newLogger(logLevel string) (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(logLevel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse log level %s: %w", logLevel, err)
+	}
+
+	loggerConfig := zap.NewProductionConfig()
+	loggerConfig.EncoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	loggerConfig.Level.SetLevel(level)
+
+	return loggerConfig.Build()
 }
 
 // healthz is a liveness probe.
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// propagation types of a mount, as reported in the propagation label
+	PROPAGATION_PRIVATE    = "private"
+	PROPAGATION_SHARED     = "shared"
+	PROPAGATION_SLAVE      = "slave"
+	PROPAGATION_UNBINDABLE = "unbindable"
+)
+
+// parseMountInfo parses the content of a mountinfo file, see proc(5):
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// The mount options are those of the mount point, the super options those of
+// the filesystem.
+func parseMountInfo(r io.Reader) ([]filesystemLabels, error) {
+	var filesystems []filesystemLabels
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+
+		// the optional fields end with a "-" separator
+		sep := -1
+		for i := 6; i < len(parts); i++ {
+			if parts[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || len(parts) < sep+4 {
+			return nil, fmt.Errorf("malformed mountinfo line: %q", scanner.Text())
+		}
+
+		mountID, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount ID in mountinfo line %q: %w", scanner.Text(), err)
+		}
+		parentID, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent ID in mountinfo line %q: %w", scanner.Text(), err)
+		}
+		dev, err := parseDeviceNumber(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid device in mountinfo line %q: %w", scanner.Text(), err)
+		}
+
+		filesystems = append(filesystems, filesystemLabels{
+			device:         unescapeMountField(parts[sep+2]),
+			mountPoint:     unescapeMountField(parts[4]),
+			fsType:         parts[sep+1],
+			options:        parts[5],
+			mountID:        mountID,
+			parentID:       parentID,
+			dev:            dev,
+			root:           unescapeMountField(parts[3]),
+			optionalFields: parts[6:sep],
+			superOptions:   parts[sep+3],
+		})
+	}
+
+	return filesystems, scanner.Err()
+}
+
+// parseDeviceNumber parses "<major>:<minor>".
+func parseDeviceNumber(s string) (deviceNumber, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return deviceNumber{}, fmt.Errorf("missing colon in %q", s)
+	}
+	major, err := strconv.ParseUint(s[:i], 10, 32)
+	if err != nil {
+		return deviceNumber{}, err
+	}
+	minor, err := strconv.ParseUint(s[i+1:], 10, 32)
+	if err != nil {
+		return deviceNumber{}, err
+	}
+	return deviceNumber{major: uint32(major), minor: uint32(minor)}, nil
+}
+
+// unescapeMountField translates the octal escapes of the space, tab, newline
+// and backslash characters in mountinfo fields.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			b.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+// propagation returns the propagation type of the mount, from its optional
+// fields. A mount both shared and slave is reported as shared.
+func (l filesystemLabels) propagation() string {
+	propagation := PROPAGATION_PRIVATE
+	for _, field := range l.optionalFields {
+		switch {
+		case strings.HasPrefix(field, "shared:"):
+			return PROPAGATION_SHARED
+		case strings.HasPrefix(field, "master:"):
+			propagation = PROPAGATION_SLAVE
+		case field == "unbindable":
+			propagation = PROPAGATION_UNBINDABLE
+		}
+	}
+	return propagation
+}
+
+// superblock identifies the filesystem behind the mount, shared by all its
+// bind mounts. The device numbers are only known from mountinfo, the mounted
+// device is used otherwise.
+func (l filesystemLabels) superblock() string {
+	if l.mountID == 0 {
+		return l.device
+	}
+	return fmt.Sprintf("%d:%d", l.dev.major, l.dev.minor)
+}
+
+// readOnly returns whether the mount point or its filesystem are read-only.
+func (l filesystemLabels) readOnly() bool {
+	for _, options := range []string{l.options, l.superOptions} {
+		for _, option := range strings.Split(options, ",") {
+			if option == "ro" {
+				return true
+			}
+		}
+	}
+	return false
+}
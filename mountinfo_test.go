@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	content := `26 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+412 26 8:32 / /var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-a/globalmount rw,relatime shared:220 - ext4 /var/lib/storageos/volumes/v.a rw
+431 26 8:32 /sub /mnt/with\040blank ro,relatime master:220 propagate_from:3 - ext4 /var/lib/storageos/volumes/v.a rw,errors=remount-ro
+440 26 8:48 / /mnt/private rw - xfs /var/lib/storageos/volumes/v.b ro,attr2
+441 26 8:48 / /mnt/unbindable rw unbindable - xfs /var/lib/storageos/volumes/v.b rw
+`
+	mounts, err := parseMountInfo(strings.NewReader(content))
+	require.NoError(t, err)
+	require.Len(t, mounts, 5)
+
+	global, bind := mounts[1], mounts[2]
+	require.Equal(t, filesystemLabels{
+		device:         "/var/lib/storageos/volumes/v.a",
+		mountPoint:     "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-a/globalmount",
+		fsType:         "ext4",
+		options:        "rw,relatime",
+		mountID:        412,
+		parentID:       26,
+		dev:            deviceNumber{major: 8, minor: 32},
+		root:           "/",
+		optionalFields: []string{"shared:220"},
+		superOptions:   "rw",
+	}, global)
+	require.Equal(t, PROPAGATION_SHARED, global.propagation())
+	require.False(t, global.readOnly())
+
+	require.Equal(t, "/mnt/with blank", bind.mountPoint)
+	require.Equal(t, "/sub", bind.root)
+	require.Equal(t, PROPAGATION_SLAVE, bind.propagation())
+	require.True(t, bind.readOnly())
+	require.Equal(t, global.superblock(), bind.superblock())
+
+	require.Equal(t, PROPAGATION_PRIVATE, mounts[3].propagation())
+	require.True(t, mounts[3].readOnly(), "read-only filesystem")
+	require.Equal(t, PROPAGATION_UNBINDABLE, mounts[4].propagation())
+	require.NotEqual(t, global.superblock(), mounts[3].superblock())
+}
+
+func TestParseMountInfoMalformed(t *testing.T) {
+	for _, line := range []string{
+		"26 1 8:1 / / rw,relatime shared:1 ext4 /dev/sda1 rw",
+		"26 1 8:1 / / rw,relatime - ext4 /dev/sda1",
+		"x 1 8:1 / / rw,relatime - ext4 /dev/sda1 rw",
+		"26 1 8-1 / / rw,relatime - ext4 /dev/sda1 rw",
+	} {
+		_, err := parseMountInfo(strings.NewReader(line))
+		require.Error(t, err, line)
+	}
+}
+
+func TestUnescapeMountField(t *testing.T) {
+	require.Equal(t, "/a b\tc\nd\\e", unescapeMountField(`/a\040b\011c\012d\134e`))
+	require.Equal(t, `/trailing\04`, unescapeMountField(`/trailing\04`))
+	require.Equal(t, `/not\0a9`, unescapeMountField(`/not\0a9`))
+}
+
+func FuzzParseMountInfo(f *testing.F) {
+	for _, seed := range fuzzSeeds(f, "root/proc/1/mountinfo") {
+		f.Add(seed)
+	}
+	f.Add(`431 26 8:32 /sub /mnt/with\040blank ro,relatime master:220 - ext4 /dev/sdc rw`)
+
+	f.Fuzz(func(t *testing.T, content string) {
+		mounts, err := parseMountInfo(strings.NewReader(content))
+		if err != nil {
+			return
+		}
+		for _, m := range mounts {
+			require.NotEmpty(t, m.fsType)
+			require.NotEmpty(t, m.superOptions)
+			require.NotEmpty(t, m.propagation())
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// quota type of project quotas, see linux/quota.h
+	PRJQUOTA = 2
+
+	// quotactl() commands of the generic quota format, used by ext4
+	Q_GETQUOTA     = 0x800007
+	Q_GETNEXTQUOTA = 0x800009
+	// quotactl() commands of the XFS quota format
+	Q_XGETQUOTA     = 'X'<<8 + 3
+	Q_XGETNEXTQUOTA = 'X'<<8 + 9
+
+	// QIF_DQBLKSIZE is the unit of the limits of the generic format, the
+	// usage is in bytes
+	QIF_DQBLKSIZE = 1024
+)
+
+// errNoProjectListing is returned when the kernel can't list the projects of a
+// filesystem, before 4.6, and no project IDs were given.
+var errNoProjectListing = errors.New("listing projects needs Q_GETNEXTQUOTA, kernel 4.6 or later")
+
+// quotactl is the quotactl() system call, overridden in tests.
+var quotactl = func(cmd uint32, special string, id uint32, addr unsafe.Pointer) error {
+	p, err := unix.BytePtrFromString(special)
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd), uintptr(unsafe.Pointer(p)), uintptr(id), uintptr(addr), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// qcmd builds a quotactl() command for project quotas.
+func qcmd(cmd uint32) uint32 {
+	return cmd<<8 | PRJQUOTA&0xff
+}
+
+// ifDqblk is struct if_dqblk of linux/quota.h, followed by the ID as in
+// struct if_nextdqblk.
+type ifDqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+	id         uint32
+}
+
+// fsDiskQuota is struct fs_disk_quota of linux/dqblk_xfs.h.
+type fsDiskQuota struct {
+	version      int8
+	flags        int8
+	fieldmask    uint16
+	id           uint32
+	blkHardlimit uint64
+	blkSoftlimit uint64
+	inoHardlimit uint64
+	inoSoftlimit uint64
+	bcount       uint64
+	icount       uint64
+	itimer       int32
+	btimer       int32
+	iwarns       uint16
+	bwarns       uint16
+	_            int32
+	rtbHardlimit uint64
+	rtbSoftlimit uint64
+	rtbcount     uint64
+	rtbtimer     int32
+	rtbwarns     uint16
+	_            int16
+	_            [8]byte
+}
+
+// projectQuota is the usage and hard limits of a project, a limit of 0 means
+// no limit.
+type projectQuota struct {
+	id          uint32
+	usedBytes   uint64
+	limitBytes  uint64
+	usedInodes  uint64
+	limitInodes uint64
+}
+
+// getProjectQuota returns the quota of the given project on the filesystem of
+// the given block device, XFS or generic format.
+func getProjectQuota(special string, xfs bool, next bool, id uint32) (projectQuota, error) {
+	if xfs {
+		cmd := uint32(Q_XGETQUOTA)
+		if next {
+			cmd = Q_XGETNEXTQUOTA
+		}
+		var q fsDiskQuota
+		if err := quotactl(qcmd(cmd), special, id, unsafe.Pointer(&q)); err != nil {
+			return projectQuota{}, err
+		}
+		if !next {
+			q.id = id
+		}
+		// the XFS format counts in basic blocks
+		return projectQuota{
+			id:          q.id,
+			usedBytes:   q.bcount * XFS_BASIC_BLOCK_SIZE,
+			limitBytes:  q.blkHardlimit * XFS_BASIC_BLOCK_SIZE,
+			usedInodes:  q.icount,
+			limitInodes: q.inoHardlimit,
+		}, nil
+	}
+
+	cmd := uint32(Q_GETQUOTA)
+	if next {
+		cmd = Q_GETNEXTQUOTA
+	}
+	var q ifDqblk
+	if err := quotactl(qcmd(cmd), special, id, unsafe.Pointer(&q)); err != nil {
+		return projectQuota{}, err
+	}
+	if !next {
+		q.id = id
+	}
+	return projectQuota{
+		id:          q.id,
+		usedBytes:   q.curspace,
+		limitBytes:  q.bhardlimit * QIF_DQBLKSIZE,
+		usedInodes:  q.curinodes,
+		limitInodes: q.ihardlimit,
+	}, nil
+}
+
+// readProjectQuotas returns the quotas of all the projects of the filesystem
+// of the given block device. Kernels before 4.6 can't list the projects, only
+// the given ones are then returned, errNoProjectListing when there are none.
+func readProjectQuotas(special string, xfs bool, ids []uint32) ([]projectQuota, error) {
+	var quotas []projectQuota
+	for id := uint32(0); ; id++ {
+		q, err := getProjectQuota(special, xfs, true, id)
+		if errors.Is(err, unix.ENOENT) {
+			return quotas, nil
+		}
+		if errors.Is(err, unix.EINVAL) && len(quotas) == 0 {
+			if len(ids) == 0 {
+				return nil, errNoProjectListing
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, q)
+
+		// the project IDs wrap around at the last one
+		id = q.id
+		if id == ^uint32(0) {
+			return quotas, nil
+		}
+	}
+
+	for _, id := range ids {
+		q, err := getProjectQuota(special, xfs, false, id)
+		if errors.Is(err, unix.ENOENT) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas, nil
+}
+
+// parseProjIDs parses a projid(5) file mapping project names to IDs:
+//
+//	# comment
+//	logfiles:42
+func parseProjIDs(r io.Reader) (map[uint32]string, error) {
+	names := map[uint32]string{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("malformed projid line: %q", line)
+		}
+		id, err := strconv.ParseUint(line[i+1:], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid project ID in projid line %q: %w", line, err)
+		}
+		names[uint32(id)] = line[:i]
+	}
+
+	return names, scanner.Err()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+const QUOTA_COLLECTOR_NAME = string(configondatv1.MetricsExporterCollectorQuota)
+
+// labels of the project quota metrics, the project is the name of the project
+// ID in the projid file, empty when unknown
+var quotaLabels = append(fsLabels, "project_id", "project")
+
+// QuotaCollector gathers the usage and limits of the project quotas of the XFS
+// and ext4 filesystems of the Ondat volumes, e.g. when a volume is shared by
+// several tenants each using its own directory.
+type QuotaCollector struct {
+	projectIDsFile string
+	// noListingOnce logs once that the kernel can't list the projects
+	noListingOnce *sync.Once
+
+	usedBytes   Metric
+	limitBytes  Metric
+	usedInodes  Metric
+	limitInodes Metric
+}
+
+func NewQuotaCollector(cfg configondatv1.MetricsExporterConfigSpec) QuotaCollector {
+	return QuotaCollector{
+		projectIDsFile: cfg.ProjectIDsFile,
+		noListingOnce:  &sync.Once{},
+		usedBytes: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "project_used_bytes"),
+			"Space used by the files of the project in bytes.",
+			quotaLabels,
+			prometheus.GaugeValue,
+		),
+		limitBytes: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "project_limit_bytes"),
+			"Hard limit of the space used by the files of the project in bytes, 0 when unlimited.",
+			quotaLabels,
+			prometheus.GaugeValue,
+		),
+		usedInodes: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "project_used_files"),
+			"Number of file nodes of the project.",
+			quotaLabels,
+			prometheus.GaugeValue,
+		),
+		limitInodes: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, FILE_SYSTEM_SUBSYSTEM, "project_limit_files"),
+			"Hard limit of the number of file nodes of the project, 0 when unlimited.",
+			quotaLabels,
+			prometheus.GaugeValue,
+		),
+	}
+}
+
+func (c QuotaCollector) Name() string {
+	return QUOTA_COLLECTOR_NAME
+}
+
+func (c QuotaCollector) Metrics() []Metric {
+	return []Metric{c.usedBytes, c.limitBytes, c.usedInodes, c.limitInodes}
+}
+
+func (c QuotaCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheus.Metric, ondatVolumes []*Volume) error {
+	log.Debug("starting quota metrics collector")
+	log = log.With("collector", QUOTA_COLLECTOR_NAME)
+
+	if len(ondatVolumes) == 0 {
+		log.Debug("no Ondat volumes, metrics collector finished early")
+		return nil
+	}
+
+	// the projects are named on a best effort basis
+	names := map[uint32]string{}
+	if c.projectIDsFile != "" {
+		data, err := readHostFile(c.projectIDsFile)
+		if err == nil {
+			names, err = parseProjIDs(bytes.NewReader(data))
+		}
+		if err != nil {
+			log.Errorw("error reading project IDs file", "path", c.projectIDsFile, "error", err)
+			names = map[uint32]string{}
+		}
+	}
+	ids := make([]uint32, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	mounts, err := listOndatMounts(log, ondatVolumes)
+	if err != nil {
+		log.Errorw("failed to read mounts", "error", err)
+		return err
+	}
+
+	for _, mount := range firstMounts(mounts) {
+		labels := mount.labels
+		if labels.fsType != "xfs" && labels.fsType != "ext4" {
+			continue
+		}
+		logScope := log.With("pvc", mount.pvc, "pvc_namespace", mount.pvcNamespace, "device", labels.device, "mountpoint", labels.mountPoint)
+
+		// quotactl() addresses the filesystem by its block device, the mount
+		// source. The Ondat device nodes are read from the state directory and
+		// the stacked ones from /dev, both mounted from the host: a /dev copied
+		// when the container started misses the devices attached since.
+		quotas, err := readProjectQuotas(hostPath(labels.device), labels.fsType == "xfs", ids)
+		if errors.Is(err, unix.ESRCH) {
+			logScope.Debug("project quotas are not enabled")
+			continue
+		}
+		if errors.Is(err, errNoProjectListing) {
+			// the same for every filesystem and scrape
+			c.noListingOnce.Do(func() {
+				log.Warnw("cannot list the projects of the filesystems, set a project IDs file to report their quotas", "error", err)
+			})
+			continue
+		}
+		if err != nil {
+			logScope.Errorw("error reading project quotas", "error", err)
+			continue
+		}
+
+		for _, q := range quotas {
+			projectID := strconv.FormatUint(uint64(q.id), 10)
+			for _, m := range []struct {
+				metric Metric
+				val    uint64
+			}{
+				{c.usedBytes, q.usedBytes},
+				{c.limitBytes, q.limitBytes},
+				{c.usedInodes, q.usedInodes},
+				{c.limitInodes, q.limitInodes},
+			} {
+				metric, err := prometheus.NewConstMetric(m.metric.desc, m.metric.valueType, float64(m.val), mount.pvc, mount.pvcNamespace, labels.device, labels.fsType, labels.mountPoint, projectID, names[q.id])
+				if err != nil {
+					logScope.Errorw("encountered error while building metric", "metric", m.metric.desc.String(), "error", err)
+					continue
+				}
+				ch <- metric
+			}
+		}
+	}
+
+	log.Debug("finished metrics collector")
+	return nil
+}
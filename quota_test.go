@@ -0,0 +1,172 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+// fakeQuotactl replaces quotactl() with one answering from the given quotas
+// of the generic format, or of the XFS one when xfs is set.
+func fakeQuotactl(t *testing.T, xfs bool, next bool, quotas []projectQuota) {
+	t.Helper()
+
+	previous := quotactl
+	t.Cleanup(func() { quotactl = previous })
+
+	quotactl = func(cmd uint32, special string, id uint32, addr unsafe.Pointer) error {
+		require.Equal(t, "/dev/sdc", special)
+
+		var found *projectQuota
+		switch cmd {
+		case qcmd(Q_XGETNEXTQUOTA), qcmd(Q_GETNEXTQUOTA):
+			if !next {
+				return unix.EINVAL
+			}
+			for i := range quotas {
+				if quotas[i].id >= id {
+					found = &quotas[i]
+					break
+				}
+			}
+		case qcmd(Q_XGETQUOTA), qcmd(Q_GETQUOTA):
+			for i := range quotas {
+				if quotas[i].id == id {
+					found = &quotas[i]
+				}
+			}
+		default:
+			t.Fatalf("unexpected command %#x", cmd)
+		}
+		require.Equal(t, xfs, cmd == qcmd(Q_XGETQUOTA) || cmd == qcmd(Q_XGETNEXTQUOTA))
+		if found == nil {
+			return unix.ENOENT
+		}
+
+		if xfs {
+			*(*fsDiskQuota)(addr) = fsDiskQuota{
+				id:           found.id,
+				bcount:       found.usedBytes / XFS_BASIC_BLOCK_SIZE,
+				blkHardlimit: found.limitBytes / XFS_BASIC_BLOCK_SIZE,
+				icount:       found.usedInodes,
+				inoHardlimit: found.limitInodes,
+			}
+			return nil
+		}
+		*(*ifDqblk)(addr) = ifDqblk{
+			id:         found.id,
+			curspace:   found.usedBytes,
+			bhardlimit: found.limitBytes / QIF_DQBLKSIZE,
+			curinodes:  found.usedInodes,
+			ihardlimit: found.limitInodes,
+		}
+		return nil
+	}
+}
+
+func TestQuotaStructs(t *testing.T) {
+	// sizes of the kernel structures
+	require.Equal(t, uintptr(72), unsafe.Sizeof(ifDqblk{}))
+	require.Equal(t, uintptr(112), unsafe.Sizeof(fsDiskQuota{}))
+}
+
+func TestReadProjectQuotas(t *testing.T) {
+	quotas := []projectQuota{
+		{id: 0, usedBytes: 4096, usedInodes: 3},
+		{id: 42, usedBytes: 1 << 20, limitBytes: 1 << 30, usedInodes: 12, limitInodes: 1000},
+		{id: 1000, usedBytes: 8192, limitBytes: 1 << 20, usedInodes: 1},
+	}
+
+	for _, xfs := range []bool{false, true} {
+		fakeQuotactl(t, xfs, true, quotas)
+		read, err := readProjectQuotas("/dev/sdc", xfs, nil)
+		require.NoError(t, err)
+		require.Equal(t, quotas, read)
+
+		// kernels without Q_GETNEXTQUOTA only report the known projects
+		fakeQuotactl(t, xfs, false, quotas)
+		read, err = readProjectQuotas("/dev/sdc", xfs, []uint32{7, 42})
+		require.NoError(t, err)
+		require.Equal(t, quotas[1:2], read)
+
+		_, err = readProjectQuotas("/dev/sdc", xfs, nil)
+		require.ErrorIs(t, err, errNoProjectListing)
+	}
+
+	previous := quotactl
+	t.Cleanup(func() { quotactl = previous })
+	quotactl = func(uint32, string, uint32, unsafe.Pointer) error { return unix.ESRCH }
+	_, err := readProjectQuotas("/dev/sdc", true, []uint32{42})
+	require.ErrorIs(t, err, unix.ESRCH)
+}
+
+func TestQuotaCollectorDevices(t *testing.T) {
+	for _, tt := range []struct {
+		host     string
+		specials []string
+	}{
+		{
+			host: "kernel-5.5",
+			specials: []string{
+				"/var/lib/storageos/volumes/v.c3561d79-459f-4e5d-b5bb-f71ae7b38672",
+				"/var/lib/storageos/volumes/v.78e88095-e690-49be-b0f3-3f735ef084a5",
+				"/var/lib/storageos/volumes/v.0b8c7a4e-5d3e-4f0c-9a27-2a1f8f3b6d11",
+			},
+		},
+		{
+			host: "stacked",
+			specials: []string{
+				"/dev/mapper/luks-c3561d79",
+				"/dev/dm-1",
+				"/var/lib/storageos/volumes/v.0b8c7a4e-5d3e-4f0c-9a27-2a1f8f3b6d11",
+			},
+		},
+	} {
+		t.Run(tt.host, func(t *testing.T) {
+			useFixtureHost(t, filepath.Join("testdata", "hosts", tt.host))
+
+			// filesystems are addressed by their mount source, not a node of
+			// the /dev of the container
+			var specials []string
+			previous := quotactl
+			t.Cleanup(func() { quotactl = previous })
+			quotactl = func(_ uint32, special string, _ uint32, _ unsafe.Pointer) error {
+				specials = append(specials, special)
+				return unix.ESRCH
+			}
+
+			log := zap.NewNop().Sugar()
+			_, err := gatherExposition(NewCollectorGroup(log, []Collector{NewQuotaCollector(configondatv1.MetricsExporterConfigSpec{})}))
+			require.NoError(t, err)
+
+			expected := make([]string, 0, len(tt.specials))
+			for _, special := range tt.specials {
+				expected = append(expected, hostPath(special))
+			}
+			require.ElementsMatch(t, expected, specials)
+		})
+	}
+}
+
+func TestParseProjIDs(t *testing.T) {
+	content := `# projects of the shared volume
+logfiles:42
+
+tenant:a:1000
+`
+	names, err := parseProjIDs(strings.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, map[uint32]string{42: "logfiles", 1000: "tenant:a"}, names)
+
+	for _, content := range []string{"logfiles\n", ":42\n", "logfiles:x\n", "logfiles:-1\n"} {
+		_, err := parseProjIDs(strings.NewReader(content))
+		require.Error(t, err, content)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// MIN_MOUNT_POLL_INTERVAL is the shortest period of the background poll of the
+// mount table, each poll parses the whole mount table of the node
+const MIN_MOUNT_POLL_INTERVAL = 100 * time.Millisecond
+
+// readOnlyTracker keeps the read-only state of mount points across scrapes
+// and, when watching, across the changes of the mount table in between, so
+// that remounts are counted even when no scrape saw the mount read-only.
+type readOnlyTracker struct {
+	// interval is the period of the background poll of the mount table, which
+	// also wakes up on its changes
+	interval  time.Duration
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	// done is closed once the watch returned
+	done chan struct{}
+
+	mtx    sync.Mutex
+	mounts map[string]*readOnlyState
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// readOnlyState is the read-only history of a mount point.
+type readOnlyState struct {
+	readOnly       bool
+	transitions    uint64
+	lastTransition time.Time
+}
+
+// newReadOnlyTracker returns a tracker polling the mount table at the given
+// interval when watching, raised to MIN_MOUNT_POLL_INTERVAL. The background
+// poll is disabled when 0.
+func newReadOnlyTracker(interval time.Duration) *readOnlyTracker {
+	if interval > 0 && interval < MIN_MOUNT_POLL_INTERVAL {
+		interval = MIN_MOUNT_POLL_INTERVAL
+	}
+	return &readOnlyTracker{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		mounts:   map[string]*readOnlyState{},
+		now:      time.Now,
+	}
+}
+
+// observe records the read-only state of the given mount point, tracking it
+// from now on. The first observation of a mount point is not a transition.
+func (t *readOnlyTracker) observe(mountPoint string, readOnly bool) readOnlyState {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	state, ok := t.mounts[mountPoint]
+	if !ok {
+		state = &readOnlyState{readOnly: readOnly}
+		t.mounts[mountPoint] = state
+	}
+	t.updateLocked(state, readOnly)
+	return *state
+}
+
+// observeTracked records the read-only state of the given mounts, ignoring
+// those not tracked.
+func (t *readOnlyTracker) observeTracked(filesystems []filesystemLabels) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, labels := range filesystems {
+		if state, ok := t.mounts[labels.mountPoint]; ok {
+			t.updateLocked(state, labels.readOnly())
+		}
+	}
+}
+
+func (t *readOnlyTracker) updateLocked(state *readOnlyState, readOnly bool) {
+	if state.readOnly == readOnly {
+		return
+	}
+	state.readOnly = readOnly
+	state.transitions++
+	state.lastTransition = t.now()
+}
+
+// forget stops tracking the mount points not in the given set.
+func (t *readOnlyTracker) forget(mountPoints map[string]struct{}) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for mountPoint := range t.mounts {
+		if _, ok := mountPoints[mountPoint]; !ok {
+			delete(t.mounts, mountPoint)
+		}
+	}
+}
+
+// start watches the mount table in the background, once whatever the number
+// of calls.
+func (t *readOnlyTracker) start(log *zap.SugaredLogger) {
+	t.startOnce.Do(func() {
+		go t.watch(log)
+	})
+}
+
+// close stops watching the mount table, waiting for the watch to return. The
+// watch can't be started afterwards.
+func (t *readOnlyTracker) close() {
+	t.stopOnce.Do(func() { close(t.stop) })
+	t.startOnce.Do(func() { close(t.done) })
+	<-t.done
+}
+
+func (t *readOnlyTracker) watch(log *zap.SugaredLogger) {
+	defer close(t.done)
+
+	// mountinfo signals the changes of the mount table with POLLPRI, the
+	// mount table is read on every interval otherwise
+	var mountInfo *os.File
+	for _, path := range []string{"/proc/1/mountinfo", "/proc/self/mountinfo"} {
+		f, err := os.Open(hostPath(path))
+		if err == nil {
+			mountInfo = f
+			defer f.Close()
+			break
+		}
+		log.Debugw("cannot watch mount table changes", "path", path, "error", err)
+	}
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		default:
+		}
+
+		filesystems, err := mountPointDetails(log)
+		if err != nil {
+			log.Debugw("failed to read mounts", "error", err)
+		} else {
+			t.observeTracked(filesystems)
+		}
+
+		waitMountTableChange(mountInfo, t.interval)
+	}
+}
+
+// waitMountTableChange waits for the given mountinfo file to signal a change
+// of the mount table, up to timeout.
+func waitMountTableChange(mountInfo *os.File, timeout time.Duration) {
+	if mountInfo != nil {
+		fds := []unix.PollFd{{Fd: int32(mountInfo.Fd()), Events: unix.POLLPRI}}
+		_, err := unix.Poll(fds, int(timeout/time.Millisecond))
+		if err == nil || err == unix.EINTR {
+			return
+		}
+	}
+	time.Sleep(timeout)
+}
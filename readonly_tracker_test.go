@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReadOnlyTracker(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tracker := newReadOnlyTracker(0)
+	tracker.now = func() time.Time { return now }
+
+	// the first observation is no transition
+	require.Equal(t, readOnlyState{}, tracker.observe("/mnt", false))
+
+	now = now.Add(time.Minute)
+	tracker.observeTracked([]filesystemLabels{
+		{mountPoint: "/mnt", options: "ro,relatime"},
+		{mountPoint: "/other", options: "ro"},
+	})
+	tracker.observeTracked([]filesystemLabels{{mountPoint: "/mnt", options: "rw", superOptions: "ro"}})
+	now = now.Add(time.Minute)
+	require.Equal(t, readOnlyState{transitions: 2, lastTransition: now}, tracker.observe("/mnt", false))
+
+	_, tracked := tracker.mounts["/other"]
+	require.False(t, tracked, "untracked mount observed")
+
+	tracker.forget(map[string]struct{}{})
+	require.Equal(t, readOnlyState{readOnly: true}, tracker.observe("/mnt", true))
+}
+
+func TestReadOnlyTrackerWatch(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "proc", "1"), 0755))
+	previousRoot := hostRoot
+	hostRoot = root
+
+	mountInfo := filepath.Join(root, "proc", "1", "mountinfo")
+	remount := func(options string) {
+		line := "431 26 8:32 / /mnt " + options + " shared:220 - ext4 /var/lib/storageos/volumes/v.a rw\n"
+		require.NoError(t, ioutil.WriteFile(mountInfo, []byte(line), 0644))
+	}
+	remount("rw,relatime")
+
+	tracker := newReadOnlyTracker(MIN_MOUNT_POLL_INTERVAL)
+	t.Cleanup(func() {
+		tracker.close()
+		hostRoot = previousRoot
+	})
+	tracker.observe("/mnt", false)
+	tracker.start(zap.NewNop().Sugar())
+
+	transitions := func() uint64 {
+		tracker.mtx.Lock()
+		defer tracker.mtx.Unlock()
+		return tracker.mounts["/mnt"].transitions
+	}
+
+	// the flap to read-only is seen without any scrape
+	remount("ro,relatime")
+	require.Eventually(t, func() bool { return transitions() == 1 }, time.Second, time.Millisecond)
+	remount("rw,relatime")
+	require.Eventually(t, func() bool { return transitions() == 2 }, time.Second, time.Millisecond)
+}
+
+func TestReadOnlyTrackerMinInterval(t *testing.T) {
+	require.Equal(t, time.Duration(0), newReadOnlyTracker(0).interval)
+	require.Equal(t, MIN_MOUNT_POLL_INTERVAL, newReadOnlyTracker(time.Millisecond).interval)
+	require.Equal(t, time.Second, newReadOnlyTracker(time.Second).interval)
+}
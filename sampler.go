@@ -0,0 +1,280 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+// diskSampler reads the stats of the Ondat devices at a short interval in the
+// background, to catch what happens between two scrapes.
+type diskSampler struct {
+	log      *zap.SugaredLogger
+	interval time.Duration
+	sources  []configondatv1.MetricsExporterDiskStatsSource
+	// buckets are the sorted upper bounds of the latency histograms, in
+	// seconds
+	buckets []float64
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+
+	mtx sync.Mutex
+	// devices to sample, as last found by a scrape
+	devices map[deviceNumber]struct{}
+	// owners are the IDs of the volumes of the sampled devices
+	owners map[deviceNumber]string
+	// last sample of each device
+	last map[deviceNumber]diskSample
+	// peaks of each device since the last scrape
+	peaks map[deviceNumber]diskPeaks
+	// latencies of each device since it is sampled
+	latencies map[deviceNumber]*diskLatencies
+}
+
+// diskSample is the subset of the stats of a device the sampler works with.
+type diskSample struct {
+	at      time.Time
+	reads   uint64
+	writes  uint64
+	sectors uint64
+	// readTicks and writeTicks are the milliseconds spent on reads and writes
+	readTicks  uint64
+	writeTicks uint64
+}
+
+// diskPeaks are the highest rates seen between two samples of a device.
+type diskPeaks struct {
+	readIOPS  float64
+	writeIOPS float64
+	// throughput is the read and written bytes per second
+	throughput float64
+}
+
+// diskLatencies are the distributions of the average read and write latency
+// of a device between two samples.
+type diskLatencies struct {
+	read  latencyHistogram
+	write latencyHistogram
+}
+
+// latencyHistogram counts observations per bucket of a diskSampler.
+type latencyHistogram struct {
+	// counts holds the number of observations of each bucket, not cumulative,
+	// with a last one for those above all the buckets
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// newDiskSampler returns a sampler of the stats of devices read from the given
+// sources. Latency histograms use the given bucket upper bounds, in seconds.
+func newDiskSampler(log *zap.SugaredLogger, interval time.Duration, sources []configondatv1.MetricsExporterDiskStatsSource, buckets []float64) *diskSampler {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	// duplicated bounds would get no observations
+	unique := sorted[:0]
+	for _, bound := range sorted {
+		if len(unique) == 0 || bound != unique[len(unique)-1] {
+			unique = append(unique, bound)
+		}
+	}
+
+	return &diskSampler{
+		log:       log,
+		interval:  interval,
+		sources:   sources,
+		buckets:   unique,
+		stop:      make(chan struct{}),
+		devices:   map[deviceNumber]struct{}{},
+		owners:    map[deviceNumber]string{},
+		last:      map[deviceNumber]diskSample{},
+		peaks:     map[deviceNumber]diskPeaks{},
+		latencies: map[deviceNumber]*diskLatencies{},
+	}
+}
+
+// start starts sampling in the background, once whatever the number of calls.
+func (s *diskSampler) start() {
+	s.startOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(s.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.stop:
+					return
+				case now := <-ticker.C:
+					s.sample(now)
+				}
+			}
+		}()
+	})
+}
+
+// close stops sampling.
+func (s *diskSampler) close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// setDevices replaces the devices to sample with the given ones, by the ID of
+// the volume owning them. The state of the devices no longer sampled is
+// dropped, as is the state of those now owned by another volume: their numbers
+// were reused by a new device.
+func (s *diskSampler) setDevices(owners map[deviceNumber]string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	devices := make(map[deviceNumber]struct{}, len(owners))
+	for num := range owners {
+		devices[num] = struct{}{}
+	}
+	s.devices = devices
+	for num, owner := range s.owners {
+		if newOwner, ok := owners[num]; !ok || newOwner != owner {
+			delete(s.last, num)
+			delete(s.peaks, num)
+			delete(s.latencies, num)
+		}
+	}
+	s.owners = owners
+}
+
+// sample reads the stats of the devices and updates their peaks.
+func (s *diskSampler) sample(now time.Time) {
+	s.mtx.Lock()
+	devices := s.devices
+	s.mtx.Unlock()
+	if len(devices) == 0 {
+		return
+	}
+
+	// read without holding the lock, a scrape mustn't wait on the host
+	reader := &deviceStatsReader{devices: devices}
+	samples := make(map[deviceNumber]diskSample, len(devices))
+	for num := range devices {
+		for _, source := range s.sources {
+			stats, found, _ := reader.read(source, int(num.major), int(num.minor))
+			if found {
+				samples[num] = diskSample{
+					at:         now,
+					reads:      stats.ReadIOs,
+					writes:     stats.WriteIOs,
+					sectors:    stats.ReadSectors + stats.WriteSectors,
+					readTicks:  stats.ReadTicks,
+					writeTicks: stats.WriteTicks,
+				}
+				break
+			}
+		}
+	}
+	if len(samples) == 0 && reader.err != nil {
+		s.log.Debugw("error sampling device stats", "error", reader.err)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for num, cur := range samples {
+		if _, ok := s.devices[num]; !ok {
+			// dropped while reading
+			continue
+		}
+		prev, ok := s.last[num]
+		s.last[num] = cur
+		if !ok {
+			continue
+		}
+
+		elapsed := cur.at.Sub(prev.at).Seconds()
+		if elapsed <= 0 || cur.reads < prev.reads || cur.writes < prev.writes || cur.sectors < prev.sectors ||
+			cur.readTicks < prev.readTicks || cur.writeTicks < prev.writeTicks {
+			// counters reset by a new device with the same numbers
+			continue
+		}
+
+		peaks := s.peaks[num]
+		peaks.readIOPS = maxFloat(peaks.readIOPS, float64(cur.reads-prev.reads)/elapsed)
+		peaks.writeIOPS = maxFloat(peaks.writeIOPS, float64(cur.writes-prev.writes)/elapsed)
+		// diskstats sectors are 512 bytes whatever the device
+		peaks.throughput = maxFloat(peaks.throughput, float64(cur.sectors-prev.sectors)*SYSFS_SECTOR_SIZE/elapsed)
+		s.peaks[num] = peaks
+
+		latencies, ok := s.latencies[num]
+		if !ok {
+			latencies = &diskLatencies{
+				read:  latencyHistogram{counts: make([]uint64, len(s.buckets)+1)},
+				write: latencyHistogram{counts: make([]uint64, len(s.buckets)+1)},
+			}
+			s.latencies[num] = latencies
+		}
+		// the average latency of the I/Os completed between the samples, ticks
+		// are milliseconds
+		if reads := cur.reads - prev.reads; reads > 0 {
+			latencies.read.observe(s.buckets, float64(cur.readTicks-prev.readTicks)/float64(reads)/1000)
+		}
+		if writes := cur.writes - prev.writes; writes > 0 {
+			latencies.write.observe(s.buckets, float64(cur.writeTicks-prev.writeTicks)/float64(writes)/1000)
+		}
+	}
+}
+
+// takePeaks returns the peaks of each device since the last call, the devices
+// sampled less than twice since have none.
+func (s *diskSampler) takePeaks() map[deviceNumber]diskPeaks {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	peaks := s.peaks
+	s.peaks = make(map[deviceNumber]diskPeaks, len(peaks))
+	return peaks
+}
+
+// latencySnapshot returns a copy of the latency histograms of each device, the
+// devices sampled less than twice have none.
+func (s *diskSampler) latencySnapshot() map[deviceNumber]diskLatencies {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	latencies := make(map[deviceNumber]diskLatencies, len(s.latencies))
+	for num, l := range s.latencies {
+		latencies[num] = diskLatencies{read: l.read.copy(), write: l.write.copy()}
+	}
+	return latencies
+}
+
+// observe adds the given value to the histogram with the given bucket upper
+// bounds.
+func (h *latencyHistogram) observe(buckets []float64, value float64) {
+	h.counts[sort.SearchFloat64s(buckets, value)]++
+	h.count++
+	h.sum += value
+}
+
+func (h latencyHistogram) copy() latencyHistogram {
+	h.counts = append([]uint64(nil), h.counts...)
+	return h
+}
+
+// cumulative returns the cumulative count of each of the given bucket upper
+// bounds, as expected by prometheus.
+func (h latencyHistogram) cumulative(buckets []float64) map[float64]uint64 {
+	counts := make(map[float64]uint64, len(buckets))
+	var total uint64
+	for i, bound := range buckets {
+		total += h.counts[i]
+		counts[bound] = total
+	}
+	return counts
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+const (
+	SAMPLER_COLLECTOR_NAME = string(configondatv1.MetricsExporterCollectorSampler)
+
+	// MIN_SAMPLING_INTERVAL is the shortest sampling interval, each sample reads
+	// the stats of all the devices
+	MIN_SAMPLING_INTERVAL = 100 * time.Millisecond
+)
+
+// SamplerCollector reports what the background sampler of the Ondat devices
+// saw since the previous scrape.
+type SamplerCollector struct {
+	sampler *diskSampler
+
+	peakReadIOPS   Metric
+	peakWriteIOPS  Metric
+	peakThroughput Metric
+	readLatency    Metric
+	writeLatency   Metric
+}
+
+// NewSamplerCollector returns a collector whose sampler reads the stats of the
+// Ondat devices at the configured interval, from the configured diskstats
+// sources, into latency histograms with the configured buckets. Sampling
+// starts on the first scrape.
+func NewSamplerCollector(log *zap.SugaredLogger, cfg configondatv1.MetricsExporterConfigSpec) SamplerCollector {
+	defaults := (&configondatv1.MetricsExporterConfig{}).Default()
+	sources := cfg.DiskStatsSources
+	if len(sources) == 0 {
+		sources = defaults.DiskStatsSources
+	}
+	latencyBuckets := cfg.LatencyBuckets
+	if len(latencyBuckets) == 0 {
+		latencyBuckets = defaults.LatencyBuckets
+	}
+	buckets := make([]float64, 0, len(latencyBuckets))
+	for _, b := range latencyBuckets {
+		buckets = append(buckets, b.Seconds())
+	}
+
+	interval := time.Duration(cfg.SamplingInterval) * time.Millisecond
+	if interval < MIN_SAMPLING_INTERVAL {
+		log.Warnw("sampling interval too short, using the minimum", "interval", interval, "minimum", MIN_SAMPLING_INTERVAL)
+		interval = MIN_SAMPLING_INTERVAL
+	}
+
+	return SamplerCollector{
+		sampler: newDiskSampler(log.With("collector", SAMPLER_COLLECTOR_NAME), interval, sources, buckets),
+		peakReadIOPS: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "peak_read_iops"),
+			"The highest rate of reads per second between two samples since the previous scrape.",
+			pvcLabels,
+			prometheus.GaugeValue,
+		),
+		peakWriteIOPS: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "peak_write_iops"),
+			"The highest rate of writes per second between two samples since the previous scrape.",
+			pvcLabels,
+			prometheus.GaugeValue,
+		),
+		peakThroughput: newMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "peak_throughput_bytes"),
+			"The highest rate of bytes read and written per second between two samples since the previous scrape.",
+			pvcLabels,
+			prometheus.GaugeValue,
+		),
+		readLatency: newHistogramMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "read_latency_seconds"),
+			"The distribution of the average latency of the reads completed between two samples.",
+			pvcLabels,
+		),
+		writeLatency: newHistogramMetric(
+			prometheus.BuildFQName(ONDAT_NAMESPACE, DISK_SUBSYSTEM, "write_latency_seconds"),
+			"The distribution of the average latency of the writes completed between two samples.",
+			pvcLabels,
+		),
+	}
+}
+
+func (c SamplerCollector) Name() string {
+	return SAMPLER_COLLECTOR_NAME
+}
+
+func (c SamplerCollector) collectsDevices() {}
+
+func (c SamplerCollector) close() {
+	c.sampler.close()
+}
+
+func (c SamplerCollector) Metrics() []Metric {
+	return []Metric{c.peakReadIOPS, c.peakWriteIOPS, c.peakThroughput, c.readLatency, c.writeLatency}
+}
+
+func (c SamplerCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheus.Metric, ondatVolumes []*Volume) error {
+	log.Debug("starting sampler metrics collector")
+	log = log.With("collector", SAMPLER_COLLECTOR_NAME)
+
+	owners := make(map[deviceNumber]string, len(ondatVolumes))
+	for _, localVol := range ondatVolumes {
+		if localVol.Major == 0 && localVol.Minor == 0 {
+			// no block device for this volume on the node
+			continue
+		}
+		owners[deviceNumber{major: uint32(localVol.Major), minor: uint32(localVol.Minor)}] = localVol.Master.VolumeID
+	}
+	// before reading the state, so that the state of a device now owned by
+	// another volume isn't reported under its PVC
+	c.sampler.setDevices(owners)
+
+	// the peaks are reset by every scrape, whatever happens next
+	peaks := c.sampler.takePeaks()
+	latencies := c.sampler.latencySnapshot()
+
+	for _, localVol := range ondatVolumes {
+		if localVol.Major == 0 && localVol.Minor == 0 {
+			continue
+		}
+		num := deviceNumber{major: uint32(localVol.Major), minor: uint32(localVol.Minor)}
+
+		logScope := log.With("pvc", localVol.Labels.PVC, "pvc_namespace", localVol.Labels.PVCNamespace)
+
+		if deviceLatencies, ok := latencies[num]; ok {
+			for _, m := range []struct {
+				metric    Metric
+				histogram latencyHistogram
+			}{
+				{c.readLatency, deviceLatencies.read},
+				{c.writeLatency, deviceLatencies.write},
+			} {
+				metric, err := prometheus.NewConstHistogram(m.metric.desc, m.histogram.count, m.histogram.sum, m.histogram.cumulative(c.sampler.buckets), localVol.Labels.PVC, localVol.Labels.PVCNamespace)
+				if err != nil {
+					logScope.Errorw("encountered error while building metric", "metric", m.metric.desc.String(), "error", err)
+					continue
+				}
+				ch <- metric
+			}
+		}
+
+		devicePeaks, ok := peaks[num]
+		if !ok {
+			// not sampled twice since the previous scrape
+			continue
+		}
+		for _, m := range []struct {
+			metric Metric
+			value  float64
+		}{
+			{c.peakReadIOPS, devicePeaks.readIOPS},
+			{c.peakWriteIOPS, devicePeaks.writeIOPS},
+			{c.peakThroughput, devicePeaks.throughput},
+		} {
+			metric, err := prometheus.NewConstMetric(m.metric.desc, m.metric.valueType, m.value, localVol.Labels.PVC, localVol.Labels.PVCNamespace)
+			if err != nil {
+				logScope.Errorw("encountered error while building metric", "metric", m.metric.desc.String(), "error", err)
+				continue
+			}
+			ch <- metric
+		}
+	}
+
+	c.sampler.start()
+
+	log.Debug("finished metrics collector")
+	return nil
+}
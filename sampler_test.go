@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+// useSampledDiskstats points the host at an empty tree and returns a function
+// writing the given stats of 8:32 into its /proc/diskstats.
+func useSampledDiskstats(t *testing.T) func(reads, readTicks, writes, writeTicks, sectors int) {
+	t.Helper()
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "proc"), 0755))
+	previousRoot := hostRoot
+	hostRoot = root
+	t.Cleanup(func() { hostRoot = previousRoot })
+
+	return func(reads, readTicks, writes, writeTicks, sectors int) {
+		line := fmt.Sprintf("   8      32 sdc %d 0 %d %d %d 0 %d %d 0 0 0\n", reads, sectors, readTicks, writes, sectors, writeTicks)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, "proc", "diskstats"), []byte(line), 0644))
+	}
+}
+
+func TestDiskSamplerPeaks(t *testing.T) {
+	writeDiskstats := useSampledDiskstats(t)
+	// writeStats sets the reads, writes and sectors read and written of 8:32
+	writeStats := func(reads, writes, sectors int) {
+		writeDiskstats(reads, 0, writes, 0, sectors)
+	}
+
+	sdc := deviceNumber{major: 8, minor: 32}
+	s := newDiskSampler(zap.NewNop().Sugar(), time.Second, []configondatv1.MetricsExporterDiskStatsSource{configondatv1.MetricsExporterDiskStatsSourceProcFS}, nil)
+	s.setDevices(map[deviceNumber]string{sdc: "a"})
+	t.Cleanup(s.close)
+
+	at := time.Unix(1600000000, 0)
+	writeStats(100, 10, 1000)
+	s.sample(at)
+	require.Empty(t, s.takePeaks(), "a single sample has no rate")
+
+	// 50 reads, 20 writes and 2*100 sectors in 500ms
+	writeStats(150, 30, 1100)
+	s.sample(at.Add(500 * time.Millisecond))
+	// then slower
+	writeStats(160, 31, 1110)
+	s.sample(at.Add(1500 * time.Millisecond))
+
+	require.Equal(t, map[deviceNumber]diskPeaks{
+		sdc: {readIOPS: 100, writeIOPS: 40, throughput: 200 * 512 * 2},
+	}, s.takePeaks())
+	require.Empty(t, s.takePeaks(), "peaks are reset when taken")
+
+	// counters going backward are a new device, not a rate
+	writeStats(5, 1, 10)
+	s.sample(at.Add(2 * time.Second))
+	require.Empty(t, s.takePeaks())
+	writeStats(6, 1, 12)
+	s.sample(at.Add(3 * time.Second))
+	require.Equal(t, map[deviceNumber]diskPeaks{
+		sdc: {readIOPS: 1, writeIOPS: 0, throughput: 4 * 512},
+	}, s.takePeaks())
+
+	// devices no longer sampled are forgotten
+	s.setDevices(map[deviceNumber]string{})
+	s.sample(at.Add(4 * time.Second))
+	require.Empty(t, s.last)
+	require.Empty(t, s.takePeaks())
+}
+
+func TestDiskSamplerLatencies(t *testing.T) {
+	writeStats := useSampledDiskstats(t)
+
+	sdc := deviceNumber{major: 8, minor: 32}
+	// unsorted and duplicated on purpose
+	s := newDiskSampler(zap.NewNop().Sugar(), time.Second, []configondatv1.MetricsExporterDiskStatsSource{configondatv1.MetricsExporterDiskStatsSourceProcFS}, []float64{0.01, 0.001, 0.01})
+	s.setDevices(map[deviceNumber]string{sdc: "a"})
+	t.Cleanup(s.close)
+	require.Equal(t, []float64{0.001, 0.01}, s.buckets)
+
+	at := time.Unix(1600000000, 0)
+	writeStats(0, 0, 0, 0, 0)
+	s.sample(at)
+	require.Empty(t, s.latencySnapshot())
+
+	// 10 reads in 5ms, 4 writes in 200ms
+	writeStats(10, 5, 4, 200, 0)
+	s.sample(at.Add(time.Second))
+	// 10 reads in 50ms, no write
+	writeStats(20, 55, 4, 200, 0)
+	s.sample(at.Add(2 * time.Second))
+
+	latencies := s.latencySnapshot()
+	require.Len(t, latencies, 1)
+	read := latencies[sdc].read
+	require.Equal(t, uint64(2), read.count)
+	require.InDelta(t, 0.0005+0.005, read.sum, 1e-9)
+	require.Equal(t, map[float64]uint64{0.001: 1, 0.01: 2}, read.cumulative(s.buckets))
+	write := latencies[sdc].write
+	require.Equal(t, uint64(1), write.count)
+	require.InDelta(t, 0.05, write.sum, 1e-9)
+	require.Equal(t, map[float64]uint64{0.001: 0, 0.01: 0}, write.cumulative(s.buckets))
+
+	// histograms are cumulative across scrapes
+	require.Equal(t, latencies, s.latencySnapshot())
+
+	// but not across the volumes a device number is reused by
+	s.setDevices(map[deviceNumber]string{sdc: "b"})
+	require.Empty(t, s.latencySnapshot())
+}
+
+func TestSamplerCollector(t *testing.T) {
+	useFixtureHost(t, filepath.Join("testdata", "hosts", "kernel-5.5"))
+
+	log := zap.NewNop().Sugar()
+	// long enough for the background sampling not to interfere
+	c := NewSamplerCollector(log, configondatv1.MetricsExporterConfigSpec{
+		SamplingInterval: int(time.Hour / time.Millisecond),
+		LatencyBuckets:   []metav1.Duration{{Duration: time.Millisecond}},
+	})
+	t.Cleanup(c.sampler.close)
+	group := NewCollectorGroup(log, []Collector{c})
+
+	// the first scrape finds the devices to sample
+	got, err := gatherExposition(group)
+	require.NoError(t, err)
+	require.NotContains(t, string(got), "ondat_disk_read_latency_seconds")
+
+	at := time.Unix(1600000000, 0)
+	c.sampler.sample(at)
+	c.sampler.sample(at.Add(time.Second))
+
+	got, err = gatherExposition(group)
+	require.NoError(t, err)
+	exposition := string(got)
+	require.Contains(t, exposition, `ondat_disk_read_latency_seconds_bucket{pvc="pvc-a",pvc_namespace="default",le="0.001"} 0`)
+	require.Contains(t, exposition, `ondat_disk_write_latency_seconds_count{pvc="pvc-b",pvc_namespace="team-b"} 0`)
+	require.Contains(t, exposition, `ondat_disk_peak_read_iops{pvc="pvc-a",pvc_namespace="default"} 0`)
+
+	// peaks are reset by the scrape, histograms are not
+	got, err = gatherExposition(group)
+	require.NoError(t, err)
+	exposition = string(got)
+	require.NotContains(t, exposition, "ondat_disk_peak_read_iops")
+	require.Contains(t, exposition, `ondat_disk_read_latency_seconds_count{pvc="pvc-a",pvc_namespace="default"} 0`)
+}
+
+func TestSamplerCollectorMinInterval(t *testing.T) {
+	c := NewSamplerCollector(zap.NewNop().Sugar(), configondatv1.MetricsExporterConfigSpec{SamplingInterval: 1})
+	require.Equal(t, MIN_SAMPLING_INTERVAL, c.sampler.interval)
+}
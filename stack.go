@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// layers of the devices stacked on top of Ondat devices, as reported in
+	// the layer label
+	LAYER_CRYPT     = "crypt"
+	LAYER_LVM       = "lvm"
+	LAYER_MULTIPATH = "multipath"
+	LAYER_DM        = "dm"
+	LAYER_MD        = "md"
+	LAYER_OTHER     = "other"
+)
+
+// stackedDevice is a block device stacked on top of the device of an Ondat
+// volume, directly or not, e.g. dm-crypt, LVM or multipath ones.
+type stackedDevice struct {
+	// name is the kernel name of the device, e.g. "dm-0"
+	name  string
+	major int
+	minor int
+	// layer is the kind of the device, one of the LAYER_* values
+	layer string
+	// mapperName is the device mapper name of the device, as found under
+	// /dev/mapper, empty when not a device mapper one
+	mapperName string
+
+	// volume is the Ondat volume the device is stacked on
+	volume *Volume
+}
+
+// stackedDevices are the devices stacked on top of Ondat devices, keyed by
+// kernel name.
+type stackedDevices map[string]*stackedDevice
+
+// discoverStackedDevices finds the devices stacked on top of the devices of
+// the given Ondat volumes, whose major and minor numbers must be known. The
+// holders of each device are followed, along with the slaves of device mapper
+// devices as holders links are missing in some setups.
+func discoverStackedDevices(log *zap.SugaredLogger, vols []*Volume) stackedDevices {
+	// kernel name to Ondat volume, for Ondat and stacked devices
+	owners := map[string]*Volume{}
+	bases := map[string]struct{}{}
+	queue := []string{}
+	for _, vol := range vols {
+		if vol.Major == 0 && vol.Minor == 0 {
+			continue
+		}
+		name, err := GetBlockDeviceName(vol.Major, vol.Minor)
+		if err != nil {
+			log.Debugw("error getting device name", "major", vol.Major, "minor", vol.Minor, "error", err)
+			continue
+		}
+		owners[name] = vol
+		bases[name] = struct{}{}
+		queue = append(queue, name)
+	}
+	if len(queue) == 0 {
+		return stackedDevices{}
+	}
+
+	// walk up the holders, breadth first
+	for len(queue) > 0 {
+		lower := queue[0]
+		queue = queue[1:]
+
+		holders, err := readHostLinks("/sys/block/" + lower + "/holders")
+		if err != nil {
+			log.Debugw("error reading device holders", "device", lower, "error", err)
+			continue
+		}
+		for _, holder := range holders {
+			if _, ok := owners[holder]; ok || !isValidDeviceName(holder) {
+				continue
+			}
+			owners[holder] = owners[lower]
+			queue = append(queue, holder)
+		}
+	}
+
+	// then down the slaves of the device mapper devices not found yet
+	slaves := map[string][]string{}
+	entries, err := readHostDir("/sys/block")
+	if err != nil {
+		log.Debugw("error listing block devices", "error", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, ok := owners[name]; ok || !strings.HasPrefix(name, "dm-") {
+			continue
+		}
+		links, err := readHostLinks("/sys/block/" + name + "/slaves")
+		if err != nil {
+			log.Debugw("error reading device slaves", "device", name, "error", err)
+			continue
+		}
+		slaves[name] = links
+	}
+	for found := true; found; {
+		found = false
+		for name, links := range slaves {
+			for _, slave := range links {
+				if vol, ok := owners[slave]; ok {
+					owners[name] = vol
+					delete(slaves, name)
+					found = true
+					break
+				}
+			}
+		}
+	}
+
+	stacked := stackedDevices{}
+	for name, vol := range owners {
+		if _, ok := bases[name]; ok {
+			continue
+		}
+		dev, err := newStackedDevice(name, vol)
+		if err != nil {
+			log.Debugw("error reading stacked device", "device", name, "error", err)
+			continue
+		}
+		stacked[name] = dev
+	}
+	return stacked
+}
+
+func newStackedDevice(name string, vol *Volume) (*stackedDevice, error) {
+	dev := &stackedDevice{name: name, volume: vol, layer: LAYER_OTHER}
+
+	numbers, err := readHostFile("/sys/block/" + name + "/dev")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(numbers)), "%d:%d", &dev.major, &dev.minor); err != nil {
+		return nil, fmt.Errorf("invalid device numbers %q: %w", numbers, err)
+	}
+
+	switch {
+	case strings.HasPrefix(name, "md"):
+		dev.layer = LAYER_MD
+	case strings.HasPrefix(name, "dm-"):
+		dev.layer = LAYER_DM
+		if mapperName, err := readHostFile("/sys/block/" + name + "/dm/name"); err == nil {
+			dev.mapperName = strings.TrimSpace(string(mapperName))
+		}
+		// the uuid prefix is set by the device mapper target's user space
+		if uuid, err := readHostFile("/sys/block/" + name + "/dm/uuid"); err == nil {
+			switch prefix := strings.SplitN(string(uuid), "-", 2)[0]; prefix {
+			case "CRYPT":
+				dev.layer = LAYER_CRYPT
+			case "LVM":
+				dev.layer = LAYER_LVM
+			case "mpath":
+				dev.layer = LAYER_MULTIPATH
+			}
+		}
+	}
+	return dev, nil
+}
+
+// byMountSource returns the stacked device mounted from the given source,
+// e.g. /dev/dm-0 or /dev/mapper/<name>.
+func (s stackedDevices) byMountSource(source string) (*stackedDevice, bool) {
+	if mapperName := strings.TrimPrefix(source, "/dev/mapper/"); mapperName != source {
+		for _, dev := range s {
+			if dev.mapperName == mapperName {
+				return dev, true
+			}
+		}
+		return nil, false
+	}
+	if name := strings.TrimPrefix(source, "/dev/"); name != source {
+		dev, ok := s[name]
+		return dev, ok
+	}
+	return nil, false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDiscoverStackedDevices(t *testing.T) {
+	useFixtureHost(t, filepath.Join("testdata", "hosts", "stacked"))
+	log := zap.NewNop().Sugar()
+
+	vols, err := GetVolumesFromLocalState(log)
+	require.NoError(t, err)
+	require.NoError(t, ExtractOndatVolumesNumbers(log, vols))
+
+	stacked := discoverStackedDevices(log, vols)
+	require.Len(t, stacked, 2, "dm-2 is stacked on a non Ondat device")
+
+	// found through the holders of sdc
+	require.Equal(t, 253, stacked["dm-0"].major)
+	require.Equal(t, 0, stacked["dm-0"].minor)
+	require.Equal(t, LAYER_CRYPT, stacked["dm-0"].layer)
+	require.Equal(t, "pvc-a", stacked["dm-0"].volume.Labels.PVC)
+
+	// found through the slaves of dm-1 only
+	require.Equal(t, LAYER_LVM, stacked["dm-1"].layer)
+	require.Equal(t, "pvc-b", stacked["dm-1"].volume.Labels.PVC)
+
+	for source, expected := range map[string]string{
+		"/dev/mapper/luks-c3561d79": "dm-0",
+		"/dev/dm-1":                 "dm-1",
+		"/dev/mapper/vg--b-data":    "dm-1",
+		"/dev/dm-2":                 "",
+		"/dev/mapper/vg--root-home": "",
+		"/dev/sdc":                  "",
+		"tmpfs":                     "",
+	} {
+		dev, ok := stacked.byMountSource(source)
+		if expected == "" {
+			require.False(t, ok, source)
+			continue
+		}
+		require.True(t, ok, source)
+		require.Equal(t, expected, dev.name, source)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// STATFS_WORKERS is the number of statfs() calls that can run at once,
+	// hence the most goroutines hung mounts can block.
+	STATFS_WORKERS = 4
+	// STATFS_QUEUE_SIZE is the number of calls that can wait for a worker.
+	STATFS_QUEUE_SIZE = 256
+)
+
+var (
+	errStatfsTimeout   = errors.New("statfs() did not return in time")
+	errStatfsQueueFull = errors.New("too many statfs() calls waiting")
+	errStatfsQueued    = errors.New("statfs() still waiting for a worker")
+)
+
+// statfsPool runs statfs() calls on a fixed number of workers, so that hung
+// mounts block those workers rather than their callers. Calls are shared per
+// mount point: a call for a mount point whose previous call didn't return yet
+// waits for that one.
+type statfsPool struct {
+	workers   int
+	startOnce sync.Once
+	jobs      chan *statfsCall
+	// onDone, when set, is called with the outcome of each call
+	onDone func(mountPoint string, err error)
+
+	mtx sync.Mutex
+	// calls are the calls waiting or running, by mount point
+	calls map[string]*statfsCall
+}
+
+// statfsCall is a statfs() call shared by all its callers. startedAt is set
+// once started is closed, buf and err once done is closed.
+type statfsCall struct {
+	mountPoint string
+	started    chan struct{}
+	startedAt  time.Time
+	done       chan struct{}
+	buf        unix.Statfs_t
+	err        error
+	// running is set while the system call runs, guarded by the pool's mtx
+	running bool
+}
+
+func newStatfsPool(workers int, onDone func(mountPoint string, err error)) *statfsPool {
+	return &statfsPool{
+		workers: workers,
+		jobs:    make(chan *statfsCall, STATFS_QUEUE_SIZE),
+		onDone:  onDone,
+		calls:   map[string]*statfsCall{},
+	}
+}
+
+// statfs calls statfs() on the given mount point and waits for it to return
+// up to the given timeout after it started, errStatfsTimeout is returned after
+// that. A call still waiting for a worker after the timeout returns
+// errStatfsQueued instead, it says nothing of the mount point. The call
+// carries on in the background.
+func (p *statfsPool) statfs(mountPoint string, timeout time.Duration, buf *unix.Statfs_t) error {
+	call, err := p.call(mountPoint)
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-call.started:
+	case <-timer.C:
+		return errStatfsQueued
+	}
+
+	// the timeout runs from the start of the system call, which may have been
+	// made for a previous caller
+	timer.Stop()
+	timer.Reset(time.Until(call.startedAt.Add(timeout)))
+	select {
+	case <-call.done:
+		*buf = call.buf
+		return call.err
+	case <-timer.C:
+		return errStatfsTimeout
+	}
+}
+
+// call returns the call of the given mount point, queuing a new one unless a
+// previous one is waiting or running.
+func (p *statfsPool) call(mountPoint string) (*statfsCall, error) {
+	p.startOnce.Do(func() {
+		for i := 0; i < p.workers; i++ {
+			go p.work()
+		}
+	})
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if call, ok := p.calls[mountPoint]; ok {
+		return call, nil
+	}
+	call := &statfsCall{mountPoint: mountPoint, started: make(chan struct{}), done: make(chan struct{})}
+	select {
+	case p.jobs <- call:
+	default:
+		return nil, errStatfsQueueFull
+	}
+	p.calls[mountPoint] = call
+	return call, nil
+}
+
+// inflight returns whether a statfs() system call is running on the given
+// mount point, rather than waiting for a worker.
+func (p *statfsPool) inflight(mountPoint string) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	call, ok := p.calls[mountPoint]
+	return ok && call.running
+}
+
+func (p *statfsPool) work() {
+	for call := range p.jobs {
+		p.mtx.Lock()
+		call.running = true
+		p.mtx.Unlock()
+		call.startedAt = time.Now()
+		close(call.started)
+
+		call.err = hostStatfs(call.mountPoint, &call.buf)
+
+		p.mtx.Lock()
+		delete(p.calls, call.mountPoint)
+		p.mtx.Unlock()
+		close(call.done)
+
+		if p.onDone != nil {
+			p.onDone(call.mountPoint, call.err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestStatfsPool(t *testing.T) {
+	// statfs() on /hung hangs until unblocked, the others return their
+	// number of calls as the block size
+	unblock := make(chan struct{})
+	var mtx sync.Mutex
+	calls := map[string]int{}
+	previousStatfs := statfs
+	statfs = func(path string, buf *unix.Statfs_t) error {
+		mtx.Lock()
+		calls[path]++
+		buf.Bsize = int64(calls[path])
+		mtx.Unlock()
+		if path == "/hung" {
+			<-unblock
+		}
+		return nil
+	}
+	t.Cleanup(func() { statfs = previousStatfs })
+
+	done := make(chan string, 8)
+	p := newStatfsPool(2, func(mountPoint string, err error) { done <- mountPoint })
+
+	// callers of a hung mount time out, sharing a single call
+	for i := 0; i < 3; i++ {
+		require.Equal(t, errStatfsTimeout, p.statfs("/hung", 10*time.Millisecond, new(unix.Statfs_t)))
+	}
+	require.True(t, p.inflight("/hung"))
+
+	// the other worker still serves the other mounts
+	buf := new(unix.Statfs_t)
+	require.NoError(t, p.statfs("/ok", time.Second, buf))
+	require.Equal(t, int64(1), buf.Bsize)
+	require.Equal(t, "/ok", <-done)
+	require.NoError(t, p.statfs("/ok", time.Second, buf))
+	require.Equal(t, int64(2), buf.Bsize)
+	require.Equal(t, "/ok", <-done)
+
+	close(unblock)
+	require.Equal(t, "/hung", <-done)
+	require.False(t, p.inflight("/hung"))
+	mtx.Lock()
+	require.Equal(t, 1, calls["/hung"])
+	mtx.Unlock()
+
+	// a new call is made once the previous one returned
+	require.NoError(t, p.statfs("/hung", time.Second, buf))
+	require.Equal(t, int64(2), buf.Bsize)
+}
+
+func TestStatfsPoolQueued(t *testing.T) {
+	unblock := make(chan struct{})
+	previousStatfs := statfs
+	statfs = func(path string, buf *unix.Statfs_t) error {
+		if path == "/hung" {
+			<-unblock
+		}
+		return nil
+	}
+	t.Cleanup(func() { statfs = previousStatfs })
+
+	p := newStatfsPool(1, nil)
+	require.Equal(t, errStatfsTimeout, p.statfs("/hung", 10*time.Millisecond, new(unix.Statfs_t)))
+
+	// the healthy mount waits for the hung one, it isn't timed out
+	require.Equal(t, errStatfsQueued, p.statfs("/ok", 10*time.Millisecond, new(unix.Statfs_t)))
+	require.False(t, p.inflight("/ok"))
+
+	close(unblock)
+	require.NoError(t, p.statfs("/ok", time.Second, new(unix.Statfs_t)))
+}
+
+func TestStatfsPoolQueueFull(t *testing.T) {
+	unblock := make(chan struct{})
+	previousStatfs := statfs
+	statfs = func(path string, buf *unix.Statfs_t) error {
+		<-unblock
+		return nil
+	}
+	t.Cleanup(func() {
+		close(unblock)
+		statfs = previousStatfs
+	})
+
+	// no worker is started before the first call, fill the queue first
+	p := newStatfsPool(1, nil)
+	p.startOnce.Do(func() {})
+	for i := 0; i < STATFS_QUEUE_SIZE; i++ {
+		_, err := p.call(string(rune('a' + i)))
+		require.NoError(t, err)
+	}
+	_, err := p.call("/full")
+	require.Equal(t, errStatfsQueueFull, err)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// STUCK_MOUNT_MAX_BACKOFF is the longest wait between two background probes
+// of a stuck mount.
+const STUCK_MOUNT_MAX_BACKOFF = 5 * time.Minute
+
+// stuckMountTracker keeps track of the mount points whose statfs() did not
+// return in time. A stuck mount is no longer probed by scrapes, it is probed
+// again in the background with an exponential backoff until a statfs() call
+// returns. Calls go through a statfsPool, so a stuck mount has at most one
+// call hanging.
+type stuckMountTracker struct {
+	// timeout is how long statfs() can take before the mount is stuck
+	timeout    time.Duration
+	maxBackoff time.Duration
+	pool       *statfsPool
+
+	mtx    sync.Mutex
+	mounts map[string]*stuckMount
+	// recoveries counts the recoveries of each mount point
+	recoveries map[string]uint64
+}
+
+// stuckMount is the state of a stuck mount point.
+type stuckMount struct {
+	since time.Time
+	// log is the logger of the scrape the mount got stuck in
+	log *zap.SugaredLogger
+}
+
+func newStuckMountTracker(timeout time.Duration) *stuckMountTracker {
+	t := &stuckMountTracker{
+		timeout:    timeout,
+		maxBackoff: STUCK_MOUNT_MAX_BACKOFF,
+		mounts:     map[string]*stuckMount{},
+		recoveries: map[string]uint64{},
+	}
+	t.pool = newStatfsPool(STATFS_WORKERS, t.answered)
+	return t
+}
+
+// statfs calls statfs() on the given mount point, marking it as stuck when
+// the call doesn't return within the timeout once running, errStatfsTimeout is
+// then returned. A call still waiting for a worker doesn't make it stuck. The
+// mount recovers when the call returns, if ever.
+func (t *stuckMountTracker) statfs(log *zap.SugaredLogger, mountPoint string, buf *unix.Statfs_t) error {
+	err := t.pool.statfs(mountPoint, t.timeout, buf)
+	if errors.Is(err, errStatfsTimeout) {
+		t.mtx.Lock()
+		defer t.mtx.Unlock()
+		t.markStuckLocked(log, mountPoint)
+	}
+	return err
+}
+
+// stuckSince returns when the given mount point got stuck, if it is.
+func (t *stuckMountTracker) stuckSince(mountPoint string) (time.Time, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	mount, ok := t.mounts[mountPoint]
+	if !ok {
+		return time.Time{}, false
+	}
+	return mount.since, true
+}
+
+// recoveryCount returns the number of times the given mount point recovered.
+func (t *stuckMountTracker) recoveryCount(mountPoint string) uint64 {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.recoveries[mountPoint]
+}
+
+// forgetRecoveries drops the recovery counts of the mount points not in the
+// given ones, e.g. those unmounted since.
+func (t *stuckMountTracker) forgetRecoveries(mountPoints map[string]struct{}) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for mountPoint := range t.recoveries {
+		if _, ok := mountPoints[mountPoint]; !ok {
+			delete(t.recoveries, mountPoint)
+		}
+	}
+}
+
+// answered is called whenever a statfs() call returns, the mount point
+// recovers if it was stuck.
+func (t *stuckMountTracker) answered(mountPoint string, err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	mount, ok := t.mounts[mountPoint]
+	if !ok {
+		return
+	}
+	mount.log.Infow("mount point has recovered, monitoring will resume", "mountpoint", mountPoint, "error", err)
+	delete(t.mounts, mountPoint)
+	t.recoveries[mountPoint]++
+}
+
+func (t *stuckMountTracker) markStuckLocked(log *zap.SugaredLogger, mountPoint string) {
+	if _, ok := t.mounts[mountPoint]; ok {
+		return
+	}
+	log.Errorw("mount point timed out, it is being labeled as stuck and will be probed in the background", "mountpoint", mountPoint)
+	mount := &stuckMount{since: time.Now(), log: log}
+	t.mounts[mountPoint] = mount
+	go t.reprobe(mountPoint, mount)
+}
+
+// reprobe calls statfs() on the given stuck mount point in the background,
+// waiting twice as long each time, until the mount recovers. No new call is
+// made while the previous one is hanging.
+func (t *stuckMountTracker) reprobe(mountPoint string, mount *stuckMount) {
+	backoff := t.timeout
+	for {
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > t.maxBackoff {
+			backoff = t.maxBackoff
+		}
+
+		t.mtx.Lock()
+		stuck := t.mounts[mountPoint] == mount
+		t.mtx.Unlock()
+		if !stuck {
+			// recovered, maybe stuck again since with its own probing
+			return
+		}
+
+		mount.log.Debugw("probing stuck mount point", "mountpoint", mountPoint)
+		if _, err := t.pool.call(mountPoint); err != nil {
+			mount.log.Debugw("error probing stuck mount point", "mountpoint", mountPoint, "error", err)
+		}
+	}
+}
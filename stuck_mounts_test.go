@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+func TestStuckMountRecovery(t *testing.T) {
+	// statfs() hangs until unblocked
+	unblock := make(chan struct{})
+	calls := make(chan struct{}, 16)
+	previousStatfs := statfs
+	statfs = func(path string, buf *unix.Statfs_t) error {
+		calls <- struct{}{}
+		<-unblock
+		return nil
+	}
+	t.Cleanup(func() { statfs = previousStatfs })
+
+	log := zap.NewNop().Sugar()
+	tracker := newStuckMountTracker(10 * time.Millisecond)
+	tracker.maxBackoff = 20 * time.Millisecond
+
+	require.Equal(t, errStatfsTimeout, tracker.statfs(log, "/mnt", new(unix.Statfs_t)))
+	_, stuck := tracker.stuckSince("/mnt")
+	require.True(t, stuck)
+	<-calls
+
+	// the background probes wait for the hanging call
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, calls, "more than one call hanging")
+	require.True(t, tracker.pool.inflight("/mnt"))
+
+	close(unblock)
+	require.Eventually(t, func() bool {
+		_, stuck := tracker.stuckSince("/mnt")
+		return !stuck
+	}, time.Second, time.Millisecond)
+	require.Equal(t, uint64(1), tracker.recoveryCount("/mnt"))
+	require.False(t, tracker.pool.inflight("/mnt"))
+
+	tracker.forgetRecoveries(map[string]struct{}{})
+	require.Zero(t, tracker.recoveryCount("/mnt"))
+}
+
+func TestStuckMountTimelyStatfs(t *testing.T) {
+	previousStatfs := statfs
+	statfs = func(path string, buf *unix.Statfs_t) error {
+		return unix.EIO
+	}
+	t.Cleanup(func() { statfs = previousStatfs })
+
+	tracker := newStuckMountTracker(10 * time.Millisecond)
+	require.Equal(t, unix.EIO, tracker.statfs(zap.NewNop().Sugar(), "/mnt", new(unix.Statfs_t)))
+
+	time.Sleep(20 * time.Millisecond)
+	_, stuck := tracker.stuckSince("/mnt")
+	require.False(t, stuck)
+	require.Zero(t, tracker.recoveryCount("/mnt"))
+}
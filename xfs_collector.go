@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs/xfs"
+	"go.uber.org/zap"
+
+	configondatv1 "github.com/ondat/metrics-exporter/api/config.storageos.com/v1"
+)
+
+const (
+	XFS_COLLECTOR_NAME = string(configondatv1.MetricsExporterCollectorXFS)
+
+	// XFS_BASIC_BLOCK_SIZE is the unit of the XFS log statistics
+	XFS_BASIC_BLOCK_SIZE = 512.0
+)
+
+// xfsStats are the statistics of /sys/fs/xfs/<dev>/stats/stats reported for
+// each XFS filesystem, along with the factor turning them into base units.
+// Order MUST match the metrics of the XFSCollector.
+var xfsStats = []struct {
+	value func(s *xfs.Stats) uint64
+	scale float64
+}{
+	{func(s *xfs.Stats) uint64 { return uint64(s.ExtentAllocation.ExtentsAllocated) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.ExtentAllocation.BlocksAllocated) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.ExtentAllocation.ExtentsFreed) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.ExtentAllocation.BlocksFreed) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.Transaction.Sync) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.Transaction.Async) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.Transaction.Empty) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.InodeOperation.Found) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.InodeOperation.Missed) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.LogOperation.Writes) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.LogOperation.Blocks) }, XFS_BASIC_BLOCK_SIZE},
+	{func(s *xfs.Stats) uint64 { return uint64(s.LogOperation.NoInternalBuffers) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.LogOperation.Force) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.LogOperation.ForceSleep) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.PushAil.SleepLogspace) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.Buffer.Get) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.Buffer.GetLockedWaited) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.Buffer.GetRead) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.ReadWrite.Read) }, 1},
+	{func(s *xfs.Stats) uint64 { return uint64(s.ReadWrite.Write) }, 1},
+	{func(s *xfs.Stats) uint64 { return s.ExtendedPrecision.ReadBytes }, 1},
+	{func(s *xfs.Stats) uint64 { return s.ExtendedPrecision.WriteBytes }, 1},
+	{func(s *xfs.Stats) uint64 { return s.ExtendedPrecision.FlushBytes }, 1},
+}
+
+// XFSCollector gathers the runtime statistics the kernel keeps for the XFS
+// filesystems of the Ondat volumes.
+type XFSCollector struct {
+	// content order MUST match xfsStats
+	metrics []Metric
+}
+
+func NewXFSCollector() XFSCollector {
+	return XFSCollector{
+		metrics: []Metric{
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "extents_allocated_total"),
+				"Number of extents allocated in the filesystem.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "blocks_allocated_total"),
+				"Number of filesystem blocks allocated.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "extents_freed_total"),
+				"Number of extents freed in the filesystem.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "blocks_freed_total"),
+				"Number of filesystem blocks freed.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "transactions_sync_total"),
+				"Number of synchronous metadata transactions.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "transactions_async_total"),
+				"Number of asynchronous metadata transactions.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "transactions_empty_total"),
+				"Number of metadata transactions that changed nothing.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "inode_cache_hits_total"),
+				"Number of inode lookups served from the inode cache.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "inode_cache_misses_total"),
+				"Number of inode lookups that missed the inode cache.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "log_writes_total"),
+				"Number of writes to the log.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "log_written_bytes_total"),
+				"Number of bytes written to the log.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "log_no_buffer_total"),
+				"Number of times a log write had no in-memory log buffer available.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "log_forces_total"),
+				"Number of times the in-memory log was forced to disk.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "log_force_sleeps_total"),
+				"Number of times a log force waited for the log to be written.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "log_space_sleeps_total"),
+				"Number of times a transaction waited for space in the log.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "buffer_gets_total"),
+				"Number of metadata buffer lookups.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "buffer_lock_waits_total"),
+				"Number of metadata buffer lookups that waited for the buffer lock.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "buffer_reads_total"),
+				"Number of metadata buffers read from the device.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "read_calls_total"),
+				"Number of read system calls on the filesystem.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "write_calls_total"),
+				"Number of write system calls on the filesystem.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "read_bytes_total"),
+				"Number of bytes read by system calls on the filesystem.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "written_bytes_total"),
+				"Number of bytes written by system calls on the filesystem.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+			newMetric(
+				prometheus.BuildFQName(ONDAT_NAMESPACE, XFS_SUBSYSTEM, "flushed_bytes_total"),
+				"Number of bytes of file data flushed to the device.",
+				fsLabels,
+				prometheus.CounterValue,
+			),
+		},
+	}
+}
+
+func (c XFSCollector) Name() string {
+	return XFS_COLLECTOR_NAME
+}
+
+func (c XFSCollector) Metrics() []Metric {
+	return c.metrics
+}
+
+func (c XFSCollector) Collect(log *zap.SugaredLogger, ch chan<- prometheus.Metric, ondatVolumes []*Volume) error {
+	log.Debug("starting xfs metrics collector")
+	log = log.With("collector", XFS_COLLECTOR_NAME)
+
+	if len(ondatVolumes) == 0 {
+		log.Debug("no Ondat volumes, metrics collector finished early")
+		return nil
+	}
+
+	mounts, err := listOndatMounts(log, ondatVolumes)
+	if err != nil {
+		log.Errorw("failed to read mounts", "error", err)
+		return err
+	}
+
+	for _, mount := range firstMounts(mounts) {
+		labels := mount.labels
+		if labels.fsType != "xfs" {
+			continue
+		}
+		logScope := log.With("pvc", mount.pvc, "pvc_namespace", mount.pvcNamespace, "device", labels.device, "mountpoint", labels.mountPoint)
+
+		if mount.dev.major == 0 && mount.dev.minor == 0 {
+			logScope.Debug("unknown device number, skipping filesystem")
+			continue
+		}
+		// XFS names its sysfs node after the kernel name of its device
+		device, err := GetBlockDeviceName(int(mount.dev.major), int(mount.dev.minor))
+		if err != nil {
+			logScope.Errorw("error getting device name", "major", mount.dev.major, "minor", mount.dev.minor, "error", err)
+			continue
+		}
+
+		// per filesystem statistics appeared in 4.4
+		data, err := readHostFile("/sys/fs/xfs/" + device + "/stats/stats")
+		if err != nil {
+			logScope.Debugw("error reading xfs statistics", "error", err)
+			continue
+		}
+		stats, err := xfs.ParseStats(bytes.NewReader(data))
+		if err != nil {
+			logScope.Errorw("error parsing xfs statistics", "error", err)
+			continue
+		}
+
+		for i, stat := range xfsStats {
+			metric, err := prometheus.NewConstMetric(c.metrics[i].desc, c.metrics[i].valueType, float64(stat.value(stats))*stat.scale, mount.pvc, mount.pvcNamespace, labels.device, labels.fsType, labels.mountPoint)
+			if err != nil {
+				logScope.Errorw("encountered error while building metric", "metric", c.metrics[i].desc.String(), "error", err)
+				continue
+			}
+			ch <- metric
+		}
+	}
+
+	log.Debug("finished metrics collector")
+	return nil
+}